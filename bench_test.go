@@ -220,3 +220,42 @@ func BenchmarkTrimStandard(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkAreReqHeadersAllowed(b *testing.B) {
+	c := initialize(Config{
+		AllowedHeaders: "Accept,Content-Type,X-Requested-With",
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.areReqHeadersAllowed("Accept, content-type,X-REQUESTED-WITH")
+	}
+}
+
+// BenchmarkFirstRequestRegex measure the first request served by a new filter, with and without the warmup of the regular expressions
+func BenchmarkFirstRequestRegex(b *testing.B) {
+	for _, warmup := range []bool{false, true} {
+		name := "cold"
+		if warmup {
+			name = "warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			res := FakeResponse{http.Header{}}
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://somedomain.com")
+			config := Config{
+				AllowedOrigins: "http://*.somedomain.*, http://*.example.*, https://app-??.example.com",
+				WarmupMatchers: warmup,
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				handler := Filter(config)(testHandler)
+				b.StartTimer()
+				handler.ServeHTTP(res, req)
+			}
+		})
+	}
+}
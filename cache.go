@@ -0,0 +1,26 @@
+package cors
+
+import "time"
+
+// Cache optional backend of the filter state shared across the instances, e.g. Redis or an in-memory LRU.
+// The filter uses it to track the preflight requests for Metrics, it must be safe for concurrent use
+type Cache interface {
+	// Get return the value of key, false if missing or expired
+	Get(key string) ([]byte, bool)
+	// Set store the value of key, it expires after ttl
+	Set(key string, val []byte, ttl time.Duration)
+}
+
+// preflightCacheKeyPrefix the prefix of the keys of the tracked preflight requests
+const preflightCacheKeyPrefix = "cors:preflight:"
+
+// trackCached record a preflight request into the cache, and return true if it was already there, i.e. seen within the window
+func (t *preflightTracker) trackCached(key string) bool {
+	key = preflightCacheKeyPrefix + key
+	_, repeated := t.cache.Get(key)
+
+	// each preflight response restarts the client cache
+	t.cache.Set(key, []byte{1}, t.window)
+
+	return repeated
+}
@@ -0,0 +1,115 @@
+package cors
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryCache an in-memory Cache, with a clock and hit/miss counters
+type memoryCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	values  map[string][]byte
+	expires map[string]time.Time
+	hits    int
+	misses  int
+}
+
+func newMemoryCache(now func() time.Time) *memoryCache {
+	return &memoryCache{now: now, values: make(map[string][]byte), expires: make(map[string]time.Time)}
+}
+
+func (m *memoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values[key]
+	if !ok || !m.now().Before(m.expires[key]) {
+		m.misses++
+		return nil, false
+	}
+	m.hits++
+
+	return v, true
+}
+
+func (m *memoryCache) Set(key string, val []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = val
+	m.expires[key] = m.now().Add(ttl)
+}
+
+func TestCachePreflightTracking(t *testing.T) {
+	now := time.Now()
+	cache := newMemoryCache(func() time.Time { return now })
+	metrics := &countMetrics{}
+
+	// two instances sharing the cache
+	var instances []http.Handler
+	for i := 0; i < 2; i++ {
+		instances = append(instances, Filter(Config{
+			AllowedOrigins: "http://foobar.com",
+			AllowedMethods: "GET,PUT,OPTIONS",
+			MaxAge:         10,
+			Metrics:        metrics,
+			Cache:          cache,
+		})(testHandler))
+	}
+
+	var tests = []struct {
+		name     string
+		instance int
+		method   string
+		elapsed  time.Duration
+		hits     int
+		misses   int
+		repeated int
+	}{
+		{"first preflight", 0, "GET", 0, 0, 1, 0},
+		{"repeated on the other instance", 1, "GET", 5 * time.Second, 1, 1, 1},
+		{"other method", 0, "PUT", 0, 1, 2, 1},
+		{"after the window", 1, "GET", 10 * time.Second, 1, 3, 1},
+		{"repeated within the new window", 0, "GET", time.Second, 2, 3, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.method)
+			instances[tt.instance].ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if cache.hits != tt.hits || cache.misses != tt.misses {
+				t.Errorf("got %d hits and %d misses, want %d and %d", cache.hits, cache.misses, tt.hits, tt.misses)
+			}
+			if metrics.repeated != tt.repeated {
+				t.Errorf("got %d repeated preflights, want %d", metrics.repeated, tt.repeated)
+			}
+		})
+	}
+}
+
+func TestCacheWithoutMetrics(t *testing.T) {
+	buf := new(bytes.Buffer)
+	Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		Cache:          newMemoryCache(time.Now),
+		Logger:         log.New(buf, "", 0),
+	})
+
+	if !strings.Contains(buf.String(), "Warning: Cache is useless without Metrics") {
+		t.Errorf("missing warning, got %q", buf.String())
+	}
+}
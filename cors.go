@@ -4,11 +4,18 @@ package cors
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -21,9 +28,27 @@ const (
 	// DefaultAllowedHeaders default allowed headers
 	DefaultAllowedHeaders = "Origin,Accept,Content-Type,Accept-Language,Content-Language,Last-Event-ID"
 
+	// SafelistedResponseHeaders CORS-safelisted response headers, browsers expose them by default
+	SafelistedResponseHeaders = "Cache-Control,Content-Language,Content-Length,Content-Type,Expires,Last-Modified,Pragma"
+
+	// LegacyAJAXHeaders headers set by legacy AJAX clients (e.g. jQuery), allowed with LegacyAJAXCompat
+	LegacyAJAXHeaders = "X-Requested-With"
+
+	// NoAllowedHeaders AllowedHeaders value that allows no non simple headers, not even the default ones
+	NoAllowedHeaders = "-"
+
 	// DefaultMaxAge default number of seconds that preflight requests can be cached by the client.
 	DefaultMaxAge = 1800
 
+	// DefaultMaxOriginLength default max length of the Origin header value: a 253 bytes host name, with scheme and port
+	DefaultMaxOriginLength = 253 + len("https://") + len(":65535")
+
+	// DefaultMaxOriginPatternSize default max number of instructions of the compiled regular expression of an origin
+	DefaultMaxOriginPatternSize = 1000
+
+	// DefaultMaxRequestMethodLen default max length of the Access-Control-Request-Method value
+	DefaultMaxRequestMethodLen = 64
+
 	// AccessControlAllowOrigin header
 	AccessControlAllowOrigin = "Access-Control-Allow-Origin"
 
@@ -42,12 +67,18 @@ const (
 	// AccessControlAllowCredentials header
 	AccessControlAllowCredentials = "Access-Control-Allow-Credentials"
 
+	// AccessControlAllowPrivateNetwork header
+	AccessControlAllowPrivateNetwork = "Access-Control-Allow-Private-Network"
+
 	// AccessControlRequestMethod header
 	AccessControlRequestMethod = "Access-Control-Request-Method"
 
 	// AccessControlRequestHeaders header
 	AccessControlRequestHeaders = "Access-Control-Request-Headers"
 
+	// AccessControlRequestPrivateNetwork header
+	AccessControlRequestPrivateNetwork = "Access-Control-Request-Private-Network"
+
 	// OriginHeader header
 	OriginHeader = "Origin"
 
@@ -60,56 +91,924 @@ const (
 	// AllowHeader header
 	AllowHeader = "Allow"
 
+	// SecFetchModeHeader header
+	SecFetchModeHeader = "Sec-Fetch-Mode"
+
+	// SecFetchSiteHeader header
+	SecFetchSiteHeader = "Sec-Fetch-Site"
+
+	// RefererHeader header
+	RefererHeader = "Referer"
+
 	// VaryHeader header
 	VaryHeader = "Vary"
 
+	// CacheControlHeader header
+	CacheControlHeader = "Cache-Control"
+
+	// CrossOriginResourcePolicyHeader header
+	CrossOriginResourcePolicyHeader = "Cross-Origin-Resource-Policy"
+
+	// WarningHeader header
+	WarningHeader = "Warning"
+
 	// HostHeader header
-	HostHeader = "Header"
+	HostHeader = "Host"
 
 	// OriginMatchAll header
 	OriginMatchAll = "*"
+
+	// OriginSelf special AllowedOrigins item, that matches the server origin (the request scheme and Host)
+	OriginSelf = "$self"
+
+	// OriginTemplateOrigin the OriginTemplate placeholder, replaced by the allowed origin
+	OriginTemplateOrigin = "{origin}"
 )
 
+// the CrossOriginResourcePolicy values
+const (
+	CORPSameOrigin  = "same-origin"
+	CORPSameSite    = "same-site"
+	CORPCrossOrigin = "cross-origin"
+)
+
+// maxTrackedPreflights maximum number of origin and method pairs tracked to detect repeated preflight requests
+const maxTrackedPreflights = 1024
+
+// forbiddenHeaders the Fetch standard forbidden request header names, scripts can't set them
+var forbiddenHeaders = map[string]bool{
+	"accept-charset":                 true,
+	"accept-encoding":                true,
+	"access-control-request-headers": true,
+	"access-control-request-method":  true,
+	"connection":                     true,
+	"content-length":                 true,
+	"cookie":                         true,
+	"cookie2":                        true,
+	"date":                           true,
+	"dnt":                            true,
+	"expect":                         true,
+	"host":                           true,
+	"keep-alive":                     true,
+	"origin":                         true,
+	"referer":                        true,
+	"te":                             true,
+	"trailer":                        true,
+	"transfer-encoding":              true,
+	"upgrade":                        true,
+	"via":                            true,
+}
+
+// requestHeaders header names sent only in requests, they are meaningless in ExposedHeaders
+var requestHeaders = map[string]bool{
+	"accept":                         true,
+	"accept-language":                true,
+	"access-control-request-headers": true,
+	"access-control-request-method":  true,
+	"authorization":                  true,
+	"cookie":                         true,
+	"if-match":                       true,
+	"if-modified-since":              true,
+	"if-none-match":                  true,
+	"origin":                         true,
+	"referer":                        true,
+	"user-agent":                     true,
+	"x-requested-with":               true,
+}
+
+// maxHeaderNameLen size of the buffer used to lower case a request header name without allocation
+const maxHeaderNameLen = 64
+
 // Config cors filter configuration
 type Config struct {
-	// AllowedOrigins comma separated list of allowed origins (default "*"), may contain whildchar ("*") for e.g. http://*.example.com
+	// AllowedOrigins comma separated list of allowed origins (default "*"), may contain whildchar ("*") for e.g. http://*.example.com.
+	// An origin starting with "*." (e.g. *.example.com) is matched as a suffix of the request origin, dot included, and this rule takes precedence.
+	// An origin ending with ".*" and without other whildchars (e.g. https://example.*) matches any top level domain,
+	// e.g. https://example.de, but not https://example.com.evil.net.
+	// An origin starting with "*://" matches any scheme, e.g. *://foobar.com, or *://*.example.com for any scheme and any subdomain of example.com.
+	// The special item "$self" matches the server origin, i.e. the request scheme and Host
 	AllowedOrigins,
 	// AllowedMethods comma separated list of methods the client is allowed to use
 	AllowedMethods,
-	// AllowedHeaders comma separated list of non simple headers the client is allowed to use
+	// AllowedHeaders comma separated list of non simple headers the client is allowed to use (default DefaultAllowedHeaders),
+	// "*" allows any header, "-" (NoAllowedHeaders) allows no non simple header.
+	// With "*" the requested headers are listed one by one in Access-Control-Allow-Headers, because with credentials "*" is a literal header name
 	AllowedHeaders,
 	// ExposedHeaders headers safe to expose
 	ExposedHeaders string
+	// LowerCaseAllowedHeaders if true, the allowed headers are advertised in lower case, instead of the AllowedHeaders casing
+	LowerCaseAllowedHeaders bool
+	// OmitSafelistedHeaders if true, the always CORS-safelisted request headers (Accept, Accept-Language, Content-Language)
+	// aren't advertised in Access-Control-Allow-Headers: browsers send them without asking, so they are still accepted.
+	// Content-Type is always advertised, it's safelisted only for the form and plain text media types, not e.g. for application/json
+	OmitSafelistedHeaders bool
+	// LegacyAJAXCompat if true, the LegacyAJAXHeaders (X-Requested-With) are added to the allowed headers
+	LegacyAJAXCompat bool
+	// ExposeSafelistedHeaders if true, the CORS-safelisted response headers (see SafelistedResponseHeaders) are always added to the exposed headers.
+	// Browsers expose them by default anyway, but some clients need them listed explicitly
+	ExposeSafelistedHeaders bool
+	// AllowHeadersOnlyIfRequested if true, the Access-Control-Allow-Headers header is emitted only if the preflight request contains Access-Control-Request-Headers
+	AllowHeadersOnlyIfRequested bool
+	// AllowedSchemes optional comma separated list of schemes (e.g. "http,https") accepted by the origins starting with "*://" or ".", as default any scheme is accepted
+	AllowedSchemes string
+	// OriginTokenHeader request header with a token signed for the origin, see VerifyOriginToken
+	OriginTokenHeader string
+	// VerifyOriginToken if not nil, the actual requests from an allowed origin must also carry in OriginTokenHeader a token valid for the origin,
+	// otherwise they are rejected with 403. The preflight requests are not verified, browsers don't send custom headers with them.
+	// Remember to add OriginTokenHeader to AllowedHeaders
+	VerifyOriginToken func(token, origin string) bool
+	// OriginAliases optional legacy origins allowed as if they were the canonical origin, the map value,
+	// that is reflected in Access-Control-Allow-Origin instead of the alias.
+	// Browsers reject a response whose Access-Control-Allow-Origin differs from Origin, so it's useful only for non browser clients
+	OriginAliases map[string]string
+	// MaxOriginPatternSize max number of instructions of the compiled regular expression of a whildchar origin,
+	// the bigger patterns are logged and ignored (default 1000)
+	MaxOriginPatternSize int
+	// WarmupMatchers if true, the regular expressions of the whildchar origins are run once at init (and on every reload),
+	// so the first real request doesn't pay their lazy initialization. Useful for latency sensitive services
+	WarmupMatchers bool
+	// MaxOrigins if > 0, the max number of AllowedOrigins entries. If exceeded, an error is logged and the origins aren't compiled:
+	// with a static AllowedOrigins no origin is allowed, the origins loaded by the OriginsLoader are ignored and the last good ones are kept
+	MaxOrigins int
+	// AllowOriginFunc optional function to validate the origin, it's consulted when the origin doesn't match AllowedOrigins.
+	// If AllowedOrigins is empty, only AllowOriginFunc is consulted
+	AllowOriginFunc func(origin string) bool
+	// RequireBothMatchers if true, AllowOriginFunc is a second stage check: it's consulted only for the origins that match AllowedOrigins,
+	// and both must allow the origin. Useful when AllowOriginFunc is expensive, e.g. it queries a database
+	RequireBothMatchers bool
+	// OriginMatchers optional custom matchers, consulted like AllowOriginFunc when the origin doesn't match AllowedOrigins.
+	// If AllowedOrigins is empty, only AllowOriginFunc and OriginMatchers are consulted
+	OriginMatchers []OriginMatcher
+	// OriginClaimVerifier optional function that returns the origins allowed for the request, e.g. from the claims of a verified partner token.
+	// It's consulted like AllowOriginFunc, the request origin must be one of the returned origins. If it fails, the origin isn't allowed.
+	// Browsers don't send custom headers or cookies with a preflight request, so as default the verifier isn't called for the preflight requests:
+	// their origin is allowed and the verifier checks the actual request
+	OriginClaimVerifier func(r *http.Request) (allowedOrigins []string, err error)
+	// VerifyClaimsOnPreflight if true, OriginClaimVerifier is called for the preflight requests too.
+	// Useful only if the verifier doesn't depend on the request headers, e.g. it checks the client certificate
+	VerifyClaimsOnPreflight bool
+	// OriginsLoader optional function that returns the allowed origins (same syntax of AllowedOrigins, one origin for each item), e.g. from a remote config service.
+	// It's called by the filter initialization, and every OriginsRefreshInterval if > 0. If the loader fails, the last good origins are kept,
+	// i.e. AllowedOrigins at the initialization: if it's empty, no origin is allowed until the loader succeeds
+	OriginsLoader func() ([]string, error)
+	// OriginsRefreshInterval interval between two calls of the OriginsLoader, as default the origins are loaded only once.
+	// The refresh runs in background until RefreshContext is done or, for the filters created by New, until Close is called
+	OriginsRefreshInterval time.Duration
+	// RefreshContext optional context that stops the background refresh of the origins when done.
+	// Without it, the refresh of the filters created by Filter and FilterByHost lasts as long as the process
+	RefreshContext context.Context
+	// PrivateCacheOnReflect if true and the reflected Access-Control-Allow-Origin changes with the request origin (i.e. more than a static origin is allowed),
+	// the Cache-Control of the allowed responses is downgraded to private, also the one set by the next handlers, so shared caches don't store them.
+	// The responses with "Cache-Control: no-store" are left untouched
+	PrivateCacheOnReflect bool
 	// MaxAge in seconds (exposed only if > 0) indicates how long the results of a preflight request can be cached
 	MaxAge int
+	// MaxAgeByOrigin optional MaxAge in seconds by origin, the keys have the same syntax of an AllowedOrigins item.
+	// Static origins are matched first, then the others in lexical order. If no origin matches, MaxAge is used
+	MaxAgeByOrigin map[string]int
+	// DeprecatedOrigins optional deprecation messages by origin, the keys have the same syntax of an AllowedOrigins item.
+	// The requests from a deprecated origin are still served, with a "Warning: 299 - <message>" header. Matched like MaxAgeByOrigin
+	DeprecatedOrigins map[string]string
+	// AllowedHeadersByMethod optional allowed headers by requested method, e.g. {"PUT": "Content-Type,X-Idempotency-Key"}.
+	// A preflight request for a method listed here is validated against its headers, not against AllowedHeaders. "*" isn't supported here
+	AllowedHeadersByMethod map[string]string
 	// AllowCredentials if true, indicates that request whether include credentials
 	AllowCredentials bool
-	// ForwardRequest forward request after preflight
+	// AllowCredentialsFunc if not nil, it's called on each request to decide whether include credentials, it overrides AllowCredentials
+	AllowCredentialsFunc func(r *http.Request) bool
+	// ForbidCredentials if true, the credentials are never allowed, whatever AllowCredentials and AllowCredentialsFunc say
+	ForbidCredentials bool
+	// LowerCaseReflectedHost if true, when the origin matches a whildchar pattern, the host of the reflected origin is lower cased,
+	// scheme and port are preserved. E.g. "http://FOO.bar.com" matched by "*.bar.com" is reflected as "http://foo.bar.com"
+	LowerCaseReflectedHost bool
+	// NormalizeReflectedOrigin if true, the default port (80 for http, 443 for https) is dropped from the reflected origin,
+	// e.g. "https://foo.com:443" is reflected as "https://foo.com"
+	NormalizeReflectedOrigin bool
+	// OriginTemplate optional template of the Access-Control-Allow-Origin value, "{origin}" is replaced by the allowed origin (default "{origin}").
+	// Only for compatibility shims, e.g. behind some gateways. The template must contain "{origin}", so the value is never a bare "*"
+	OriginTemplate string
+	// CredentialsOnlyForExactOrigins if true, the credentials are allowed only for the origins that match a static origin (or "$self"),
+	// not for the ones that match a whildchar pattern or AllowOriginFunc
+	CredentialsOnlyForExactOrigins bool
+	// RequireTLSForCredentials if true, the credentials are allowed only for the requests received over TLS (r.TLS != nil).
+	// Behind a TLS terminating proxy r.TLS is always nil, so the credentials are never allowed
+	RequireTLSForCredentials bool
+	// ForwardRequest forward request after preflight. If false, the preflight response is written by the filter
+	// and the next handlers are never called, so apply the filter before any expensive middleware
 	ForwardRequest bool
+	// ForwardRequestPaths optional path prefixes, e.g. "/custom", the preflights are forwarded only for the paths starting with one of them,
+	// elsewhere the filter writes the preflight response. It implies ForwardRequest for these paths
+	ForwardRequestPaths []string
+	// StripOriginOnForward if true, the Origin header is removed from the preflight requests forwarded with ForwardRequest
+	StripOriginOnForward bool
+	// ForwardDisallowedPreflight if true, the disallowed preflight requests are forwarded to the next handler, e.g. to log them,
+	// the response status is still the rejection one (e.g. 405). Meant for debugging
+	ForwardDisallowedPreflight bool
+	// PreflightMethodRejectStatus the status code of the preflight responses with a disallowed requested method (default 405)
+	PreflightMethodRejectStatus int
+	// PreflightHeaderRejectStatus the status code of the preflight responses with disallowed requested headers (default 403).
+	// Some implementations answer 400 to all the bad preflights
+	PreflightHeaderRejectStatus int
+	// SuppressHeadersOnServerError if true, the CORS headers are removed from the responses with a 5xx status code written by the next handler,
+	// so scripts can't read the error. By default the CORS headers are kept, and scripts can read the error
+	SuppressHeadersOnServerError bool
+	// DefaultOptionsHandler optional handler for the OPTIONS requests without Origin, i.e. not CORS requests,
+	// e.g. to answer them with 204 and an Allow header. If nil, they are forwarded to the next handler
+	DefaultOptionsHandler http.Handler
+	// CrossOriginResourcePolicy optional Cross-Origin-Resource-Policy header value (CORPSameOrigin, CORPSameSite or CORPCrossOrigin),
+	// emitted on all the responses, CORS requests or not
+	CrossOriginResourcePolicy string
+	// RejectSimpleMethodPreflight if true, preflight requests for a simple method (GET, HEAD or POST) without non simple headers are rejected with 400.
+	// Browsers never send such preflight requests
+	RejectSimpleMethodPreflight bool
+	// StrictRequestHeaders if true, preflight requests with a forbidden header name (see the Fetch standard, e.g. Host or Content-Length)
+	// in Access-Control-Request-Headers are rejected with 400. Scripts can't set those headers, so the preflight request is malformed
+	StrictRequestHeaders bool
+	// AllowPrivateNetwork if true, preflight requests with "Access-Control-Request-Private-Network: true" (Private Network Access)
+	// are answered with "Access-Control-Allow-Private-Network: true"
+	AllowPrivateNetwork bool
+	// PrivateNetworkOrigins optional comma separated list of origins, with the same syntax of AllowedOrigins, granted the private network access.
+	// If set, "Access-Control-Allow-Private-Network: true" is emitted only for these origins, AllowPrivateNetwork is implied
+	PrivateNetworkOrigins string
+	// AdvertiseOptions if true, OPTIONS is appended to the Access-Control-Allow-Methods header of preflight responses, if missing.
+	//
+	// Deprecated: use MethodAdvertisement = AdvertiseFullPlusOptions
+	AdvertiseOptions bool
+	// MethodAdvertisement what the preflight responses advertise in Access-Control-Allow-Methods (default AdvertiseFull)
+	MethodAdvertisement MethodAdvertisement
+	// MethodsProvider optional function that returns the methods valid for the requested resource (e.g. the methods of the matched route).
+	// If set, preflight requests are validated against, and advertise exactly, the returned methods that AllowedMethods allows too
+	MethodsProvider func(r *http.Request) []string
+	// RequireCORSFetchMode if true, the cross origin POST, PUT, PATCH and DELETE requests without "Sec-Fetch-Mode: cors" are rejected with 403,
+	// e.g. a cross origin form submission. Useful against CSRF, but only the browsers that support the fetch metadata send the header
+	RequireCORSFetchMode bool
+	// OptionsAlways200 if true, an OPTIONS request from an allowed origin without Access-Control-Request-Method
+	// gets 200 with the basic CORS headers instead of 405
+	OptionsAlways200 bool
+	// SilentReject if true, requests from disallowed origins are forwarded without CORS headers instead of being rejected with 403,
+	// like a same origin request. The browser blocks the response anyway
+	SilentReject bool
+	// ReportOnly if true, the requests from disallowed origins are served anyway, with their origin reflected but without credentials,
+	// and logged. Meant to roll out a new policy, like a CSP report only policy
+	ReportOnly bool
+	// ReportURI optional URI where a ViolationReport is POSTed, as JSON, for each request from an origin the policy would block in ReportOnly mode.
+	// The reports are best-effort: only a few are sent at the same time, the others are dropped and logged
+	ReportURI string
+	// StrictMethodCase if true, preflight requests whose Access-Control-Request-Method isn't upper case are rejected with 400.
+	// Browsers always upper case it, so a lower case method comes from a non browser client
+	StrictMethodCase bool
+	// MaxRequestMethodLen max length of the Access-Control-Request-Method value, longer values are rejected with 400 (default 64)
+	MaxRequestMethodLen int
+	// MaxResponseHeaderBytes optional max length of the Access-Control-Allow-Headers and Access-Control-Expose-Headers values,
+	// longer lists are truncated after the last header name that fits. Useful for tiny servers with long lists.
+	// If all headers are allowed, the preflight requests whose requested headers are longer are rejected with PreflightHeaderRejectStatus
+	MaxResponseHeaderBytes int
+	// MaxOriginLength max length of the Origin header value, longer origins are rejected with 403 before any matching (default DefaultMaxOriginLength)
+	MaxOriginLength int
+	// SkipSameOrigin if true, the requests whose Origin has the same scheme, host and port of the request are forwarded
+	// without CORS processing, like the requests without Origin
+	SkipSameOrigin bool
+	// RejectSchemeDowngrade if true, once a static allowed origin (e.g. "https://foobar.com") has been seen over https, the same host over http
+	// is rejected with 403, e.g. an http origin injected by a man in the middle. The seen hosts are tracked per process, in a bounded set,
+	// so it's best-effort. The Origin header is set by the client: any client can send the https origin first and deny the http one,
+	// so enable it only if the http origins of the listed hosts must never be served. The patterns, "*" and the reflected origins are never tracked
+	RejectSchemeDowngrade bool
+	// OmitSameOriginVary if true, with SkipSameOrigin the same origin requests don't get "Vary: Origin", e.g. to cache better the same origin POSTs.
+	// Only if the URL is never requested cross origin: a shared cache could serve the same origin response, without the CORS headers, to another origin
+	OmitSameOriginVary bool
+	// UseFetchMetadata if true, the requests with "Sec-Fetch-Site: same-origin" or "Sec-Fetch-Site: none" (e.g. typed in the address bar)
+	// get no CORS headers, they are added only to the "cross-site" and "same-site" ones. The checks enforced by the server
+	// (allowed origins, methods, origin token, fetch mode, ...) still apply, the server origin is always allowed, and the responses
+	// get "Vary: Origin, Sec-Fetch-Site". Sec-Fetch-Site is sent only by the modern browsers, the requests without it are handled as usual
+	UseFetchMetadata bool
+	// FallbackToReferer if true, when the Origin header is missing the origin is derived from the Referer scheme and host and matched as usual.
+	// It's less reliable than Origin: the Referer can be stripped or truncated by the Referrer-Policy, and it's sent by same origin requests too,
+	// so a Referer with the server origin is handled as a same origin request. The navigations ("Sec-Fetch-Mode: navigate", or without
+	// Sec-Fetch-Mode the GET and HEAD requests) never fall back, so a link from any site still works. The responses without Origin get "Vary: Referer, Sec-Fetch-Mode"
+	FallbackToReferer bool
+	// CanonicalVary if true, the Vary header values added by the filter and by the next handlers are merged in a single header,
+	// deduplicated case-insensitively and sorted, to get stable cache keys
+	CanonicalVary bool
+	// Tracer optional hook to wrap each CORS evaluation in a span, with the origin, the matcher type and the decision as attributes
+	Tracer Tracer
+	// Metrics optional hook to collect the filter counters
+	Metrics Metrics
+	// Cache optional backend shared across the instances, e.g. Redis, used to track the preflight requests for Metrics.
+	// It's used only if Metrics is set, otherwise it's ignored. If nil, the tracking is in memory and per instance
+	Cache Cache
 	// Logger optional logger
 	Logger *log.Logger
+	// LogLevel the verbosity of the request log lines, by default (LogWarn) only the rejected requests are logged
+	LogLevel LogLevel
+	// CorrelationHeader optional request header (e.g. X-Request-ID) with a correlation id, added to the log lines of the request
+	CorrelationHeader string
+
+	// nowFunc the clock of the time dependent features, time.Now if nil. It's set only by the tests, with setNow
+	nowFunc func() time.Time
 }
 
-// cors the filter struct
-type cors struct {
-	logWrap              func(format string, v ...interface{})
+// setNow set the clock, for deterministic tests
+func (config *Config) setNow(now func() time.Time) {
+	config.nowFunc = now
+}
+
+// MethodAdvertisement the methods advertised in the Access-Control-Allow-Methods header of the preflight responses
+type MethodAdvertisement int
+
+// the method advertisement strategies, the zero value is AdvertiseFull
+const (
+	AdvertiseFull            MethodAdvertisement = iota // the allowed methods (or the MethodsProvider ones)
+	AdvertiseReflectRequest                             // only the requested method
+	AdvertiseFullPlusOptions                            // the allowed methods, with OPTIONS appended if missing
+)
+
+// LogLevel the verbosity of the request log lines
+type LogLevel int
+
+// the log levels, the zero value is LogWarn
+const (
+	LogError LogLevel = iota - 1 // only the errors, no request is logged
+	LogWarn                      // the rejected requests
+	LogInfo                      // the rejected requests and the allowed preflight requests
+	LogDebug                     // all requests
+)
+
+// Metrics hook to collect the filter counters
+type Metrics interface {
+	// RepeatedPreflight is called when a preflight request for an origin and method pair already seen within the MaxAge window is received.
+	// The client could have cached the previous preflight response, an high rate of repeated preflights may indicate a MaxAge too low
+	RepeatedPreflight(origin, method string)
+}
+
+// preflightTracker track the preflight requests, to detect the ones that could have been cached by the client
+type preflightTracker struct {
+	mu     sync.Mutex
+	window time.Duration
+	now    func() time.Time
+	seen   map[string]time.Time
+	cache  Cache // if set, it replaces seen
+}
+
+// newPreflightTracker return a tracker of the preflight requests seen within window, now is the clock
+func newPreflightTracker(window time.Duration, now func() time.Time) *preflightTracker {
+	return &preflightTracker{
+		window: window,
+		now:    now,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// track record a preflight request for the origin and method pair, and return true if the same pair was already seen within the window
+func (t *preflightTracker) track(origin, method string) bool {
+	key := origin + " " + method
+	if t.cache != nil {
+		return t.trackCached(key)
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.seen[key]
+	repeated := ok && now.Sub(last) < t.window
+
+	if !ok && len(t.seen) >= maxTrackedPreflights {
+		// keep the state bounded, drop the expired pairs first, then all of them
+		for k, v := range t.seen {
+			if now.Sub(v) >= t.window {
+				delete(t.seen, k)
+			}
+		}
+		if len(t.seen) >= maxTrackedPreflights {
+			t.seen = make(map[string]time.Time)
+		}
+	}
+
+	// each preflight response restarts the client cache
+	t.seen[key] = now
+
+	return repeated
+}
+
+// OriginMatcher a custom origin matcher
+type OriginMatcher interface {
+	// Match return true if the origin is allowed
+	Match(origin string) bool
+}
+
+// originMatch how an origin matched the allowed origins
+type originMatch int
+
+const (
+	matchNone    originMatch = iota // no match
+	matchAll                        // all origins are allowed
+	matchStatic                     // static origin
+	matchSelf                       // server origin
+	matchPattern                    // suffix, top level domain, any scheme or regular expression origin
+	matchFunc                       // AllowOriginFunc or a custom OriginMatcher
+)
+
+// parsedOrigin the components of an origin, parsed once per request
+type parsedOrigin struct {
+	raw    string // the origin as sent by the client
+	scheme string // lower case scheme, empty if the origin has no scheme
+	host   string // host, without brackets for IPv6 addresses
+	port   string // port, empty if missing
+}
+
+// parseOrigin split the origin in scheme, host and port, path, query and fragment are ignored
+func parseOrigin(origin string) parsedOrigin {
+	p := parsedOrigin{raw: origin}
+
+	i := strings.Index(origin, "://")
+	if i <= 0 {
+		return p
+	}
+
+	p.scheme = strings.ToLower(origin[:i])
+	hostPort := origin[i+len("://"):]
+	if j := strings.IndexAny(hostPort, "/?#"); j >= 0 {
+		hostPort = hostPort[:j]
+	}
+
+	// the port follows the last colon, but only if it's outside the IPv6 brackets
+	if j := strings.LastIndexByte(hostPort, ':'); j >= 0 && j > strings.LastIndexByte(hostPort, ']') {
+		p.port = hostPort[j+1:]
+		hostPort = hostPort[:j]
+	}
+
+	if strings.HasPrefix(hostPort, "[") && strings.HasSuffix(hostPort, "]") {
+		hostPort = hostPort[1 : len(hostPort)-1]
+	}
+	p.host = hostPort
+
+	return p
+}
+
+// hostPort return host[:port], as written in the origin
+func (p parsedOrigin) hostPort() string {
+	host := p.host
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+
+	if p.port == "" {
+		return host
+	}
+
+	return host + ":" + p.port
+}
+
+// lowerCaseHost return the origin without path, query and fragment, with the host in lower case, scheme and port are preserved
+func (p parsedOrigin) lowerCaseHost() string {
+	if p.scheme == "" {
+		return p.raw
+	}
+
+	return p.raw[:strings.Index(p.raw, "://")+len("://")] + strings.ToLower(p.hostPort())
+}
+
+// effectivePort return the port, or the default one for the scheme if missing
+func (p parsedOrigin) effectivePort() string {
+	if p.port != "" {
+		return p.port
+	}
+
+	return defaultPort(p.scheme)
+}
+
+// defaultPort return the default port of the scheme, empty if unknown
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+
+	return ""
+}
+
+// dropDefaultPort return the origin, scheme://host[:port], without the port if it's the default one for the scheme,
+// e.g. "https://foo.com:443" becomes "https://foo.com", as browsers serialize it
+func dropDefaultPort(origin string) string {
+	p := parseOrigin(origin)
+	if p.port != "" && p.port == defaultPort(p.scheme) {
+		return origin[:len(origin)-len(":"+p.port)]
+	}
+
+	return origin
+}
+
+// sameOrigin return true if p and o have the same scheme, host and effective port
+func (p parsedOrigin) sameOrigin(o parsedOrigin) bool {
+	return p.scheme != "" && p.scheme == o.scheme && strings.EqualFold(p.host, o.host) && p.effectivePort() == o.effectivePort()
+}
+
+// portRange an origin whose port is within a range, e.g. "http://localhost:8000-8999"
+type portRange struct {
+	scheme, host string
+	min, max     int
+}
+
+// parsePortRange parse an origin with a port range, ok is false if origin has no valid port range
+func parsePortRange(origin string) (r portRange, ok bool) {
+	p := parseOrigin(origin)
+	i := strings.IndexByte(p.port, '-')
+	if p.scheme == "" || p.host == "" || i < 0 {
+		return r, false
+	}
+
+	min, err := strconv.Atoi(p.port[:i])
+	if err != nil {
+		return r, false
+	}
+	max, err := strconv.Atoi(p.port[i+1:])
+	if err != nil || min < 1 || max > 65535 || min > max {
+		return r, false
+	}
+
+	return portRange{scheme: p.scheme, host: strings.ToLower(p.host), min: min, max: max}, true
+}
+
+// match return true if the origin has the same scheme and host, and its port is in the range
+func (r portRange) match(p parsedOrigin) bool {
+	if p.scheme != r.scheme || !strings.EqualFold(p.host, r.host) {
+		return false
+	}
+
+	port, err := strconv.Atoi(p.effectivePort())
+
+	return err == nil && r.min <= port && port <= r.max
+}
+
+// String return the origin form of the range
+func (r portRange) String() string {
+	return fmt.Sprintf("%s://%s:%d-%d", r.scheme, parsedOrigin{host: r.host}.hostPort(), r.min, r.max)
+}
+
+// origins the compiled allowed origins
+type origins struct {
 	allowedRegexOrigins  []*regexp.Regexp // store pre-compiled regular expression to match
 	allowedStaticOrigins []string         // store static origin to match
 	allowedSuffixOrigins []string         // store suffix origin to match
+	allowedTLDOrigins    []string         // store origin prefix, without the top level domain, to match
+	allowedApexOrigins   []string         // store domains to match, with all their subdomains, lower case and punycode encoded
+	excludedOrigins      []string         // store origins and hosts never allowed, even if they match another origin
+	allowedPortRanges    []portRange      // store origins with a range of ports to match
+	// the next two slices store the hosts and the host suffixes to match with any scheme
+	allowedAnySchemeOrigins       []string
+	allowedAnySchemeSuffixOrigins []string
+	allowAllOrigins               bool
+	allowSelf                     bool // match the server origin
+}
+
+// originWildchar the regular expression of a whildchar, it never crosses the origin boundary (path, query, fragment or user info)
+const originWildchar = "[^/?#@]"
+
+// originPattern compile the regular expression of a whildchar origin, anchored to match the whole origin.
+// maxSize is the max number of instructions of the compiled program, 0 means no limit
+func originPattern(origin string, maxSize int) (*regexp.Regexp, error) {
+	p := regexp.QuoteMeta(strings.TrimSpace(origin))
+	p = strings.Replace(p, "\\*", originWildchar+"*", -1)
+	p = strings.Replace(p, "\\?", originWildchar, -1)
+	p = "^" + p + "$"
+
+	if maxSize > 0 {
+		re, err := syntax.Parse(p, syntax.Perl)
+		if err != nil {
+			return nil, err
+		}
+		prog, err := syntax.Compile(re.Simplify())
+		if err != nil {
+			return nil, err
+		}
+		if len(prog.Inst) > maxSize {
+			return nil, fmt.Errorf("origin %q is too complex, its compiled program has %d instructions, more than %d", origin, len(prog.Inst), maxSize)
+		}
+	}
+
+	return regexp.Compile(p)
+}
+
+// compileOrigins compile the list of allowed origins, the too complex patterns are logged and ignored
+func (c *cors) compileOrigins(list []string) *origins {
+	o := &origins{}
+
+	// the exclusions, e.g. "*.example.com !admin.example.com"
+	if strings.Contains(strings.Join(list, ","), "!") {
+		var patterns []string
+		for _, origin := range list {
+			for _, f := range strings.Fields(origin) {
+				if strings.HasPrefix(f, "!") {
+					o.excludedOrigins = append(o.excludedOrigins, f[1:])
+				} else {
+					patterns = append(patterns, f)
+				}
+			}
+		}
+		list = patterns
+	}
+
+	// different type of origins...
+	for _, origin := range list {
+		if t := strings.TrimSpace(origin); t == OriginMatchAll {
+			o.allowAllOrigins = true
+		} else if t == OriginSelf {
+			o.allowSelf = true
+		} else if strings.HasPrefix(t, "*://") {
+			host := t[len("*://"):]
+			if strings.HasPrefix(host, "*.") {
+				o.allowedAnySchemeSuffixOrigins = append(o.allowedAnySchemeSuffixOrigins, strings.ToLower(toASCII(host[1:])))
+			} else {
+				o.allowedAnySchemeOrigins = append(o.allowedAnySchemeOrigins, host)
+			}
+		} else if strings.HasPrefix(t, ".") && !strings.ContainsAny(t, "*?") {
+			o.allowedApexOrigins = append(o.allowedApexOrigins, strings.ToLower(toASCII(t[1:])))
+		} else if r, ok := parsePortRange(t); ok {
+			o.allowedPortRanges = append(o.allowedPortRanges, r)
+		} else if !strings.ContainsAny(origin, "*") {
+			o.allowedStaticOrigins = append(o.allowedStaticOrigins, origin)
+		} else if strings.HasPrefix(t, "*.") {
+			// keep the dot, *.example.com must not match evilexample.com
+			o.allowedSuffixOrigins = append(o.allowedSuffixOrigins, strings.ToLower(toASCII(t[1:])))
+		} else if strings.HasSuffix(origin, ".*") && strings.Count(origin, "*") == 1 {
+			o.allowedTLDOrigins = append(o.allowedTLDOrigins, strings.TrimSpace(origin[:len(origin)-1]))
+		} else if strings.Count(origin, "*") > 0 || strings.Count(origin, "?") > 0 {
+			r, err := originPattern(origin, c.maxOriginPatternSize)
+			if err != nil {
+				c.logWrap("Error: ignore the allowed origin, %s", err)
+				continue
+			}
+			o.allowedRegexOrigins = append(o.allowedRegexOrigins, r)
+		}
+	}
+
+	if c.warmupMatchers {
+		o.warmup()
+	}
+
+	return o
+}
+
+// warmupOrigin the dummy origin used to warm up the regular expressions
+const warmupOrigin = "https://warmup.invalid"
+
+// warmup run each regular expression once, to pay at init the allocation of its matching machine
+func (o *origins) warmup() {
+	for _, r := range o.allowedRegexOrigins {
+		r.MatchString(warmupOrigin)
+	}
+}
+
+// patterns return a human readable description of each compiled matcher
+func (o *origins) patterns() []string {
+	var p []string
+
+	if o.allowAllOrigins {
+		p = append(p, OriginMatchAll)
+	}
+
+	if o.allowSelf {
+		p = append(p, OriginSelf)
+	}
+
+	p = append(p, o.allowedStaticOrigins...)
+
+	for _, s := range o.allowedSuffixOrigins {
+		p = append(p, "*"+s)
+	}
+
+	for _, s := range o.allowedAnySchemeOrigins {
+		p = append(p, "*://"+s)
+	}
+
+	for _, s := range o.allowedAnySchemeSuffixOrigins {
+		p = append(p, "*://*"+s)
+	}
+
+	for _, s := range o.allowedTLDOrigins {
+		p = append(p, s+"*")
+	}
+
+	for _, s := range o.allowedApexOrigins {
+		p = append(p, "."+s)
+	}
+
+	for _, r := range o.allowedRegexOrigins {
+		p = append(p, "regexp:"+r.String())
+	}
+
+	for _, r := range o.allowedPortRanges {
+		p = append(p, r.String())
+	}
+
+	for _, s := range o.excludedOrigins {
+		p = append(p, "!"+s)
+	}
+
+	return p
+}
+
+// isExcluded return true if the origin, or its host if the exclusion has no scheme, is excluded
+func (o *origins) isExcluded(p parsedOrigin) bool {
+	for _, s := range o.excludedOrigins {
+		if strings.Contains(s, "://") {
+			if s == p.raw {
+				return true
+			}
+		} else if p.scheme != "" && strings.EqualFold(s, p.hostPort()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAllowed return true if the origin match, schemes are the ones accepted by the any scheme origins
+func (o *origins) isAllowed(origin parsedOrigin, schemes map[string]bool) bool {
+	return o.match(origin, schemes) != matchNone
+}
+
+// match return how the origin match, schemes are the ones accepted by the any scheme origins
+func (o *origins) match(p parsedOrigin, schemes map[string]bool) originMatch {
+	origin := p.raw
+
+	if o.isExcluded(p) {
+		return matchNone
+	}
+
+	if o.allowAllOrigins {
+		return matchAll
+	}
+
+	for _, s := range o.allowedStaticOrigins {
+		if s == origin {
+			return matchStatic
+		}
+	}
+
+	// the suffixes are lower case and punycode encoded, the scheme and the host are case insensitive
+	if len(o.allowedSuffixOrigins) > 0 {
+		ascii := toASCIIOrigin(origin)
+		for _, s := range o.allowedSuffixOrigins {
+			if len(ascii) >= len(s) && hasSuffixFold(ascii, s) {
+				return matchPattern
+			}
+		}
+	}
+
+	if len(o.allowedAnySchemeOrigins) > 0 || len(o.allowedAnySchemeSuffixOrigins) > 0 {
+		if p.scheme != "" && (schemes == nil || schemes[p.scheme]) {
+			host := p.hostPort()
+
+			for _, s := range o.allowedAnySchemeOrigins {
+				if s == host {
+					return matchPattern
+				}
+			}
+
+			ascii := toASCII(host)
+			for _, s := range o.allowedAnySchemeSuffixOrigins {
+				if len(ascii) > len(s) && hasSuffixFold(ascii, s) {
+					return matchPattern
+				}
+			}
+		}
+	}
+
+	for _, s := range o.allowedTLDOrigins {
+		if strings.HasPrefix(origin, s) && isTLD(origin[len(s):]) {
+			return matchPattern
+		}
+	}
+
+	if len(o.allowedApexOrigins) > 0 && p.scheme != "" && (schemes == nil || schemes[p.scheme]) {
+		host := toASCII(p.hostPort())
+		for _, s := range o.allowedApexOrigins {
+			if strings.EqualFold(host, s) || len(host) > len(s) && hasSuffixFold(host, s) && host[len(host)-len(s)-1] == '.' {
+				return matchPattern
+			}
+		}
+	}
+
+	for _, r := range o.allowedPortRanges {
+		if r.match(p) {
+			return matchPattern
+		}
+	}
+
+	for _, r := range o.allowedRegexOrigins {
+		if r.MatchString(origin) {
+			return matchPattern
+		}
+	}
+
+	return matchNone
+}
+
+// originMaxAge the MaxAge for the matching origins
+type originMaxAge struct {
+	origins *origins
+	maxAge  string
+}
+
+// methodHeaders the allowed headers of a method
+type methodHeaders struct {
+	allowed map[string]bool
+	list    string
+}
+
+// originWarning the Warning header value for the matching origins
+type originWarning struct {
+	origins *origins
+	warning string
+}
+
+// cors the filter struct
+type cors struct {
+	logWrap func(format string, v ...interface{})
+	// origins can be replaced at runtime by the origins loader, always access them through getOrigins
+	originsMu         sync.RWMutex
+	origins           *origins
+	originsLoader     func() ([]string, error)
+	done              chan struct{} // closed by close, it stops the background goroutines
+	closeOnce         sync.Once
+	originFunc        func(origin string) bool
+	requireBoth       bool
+	claimVerifier     func(r *http.Request) ([]string, error)
+	claimsOnPreflight bool
+	originMatchers    []OriginMatcher
+	originAliases     map[string]string // alias -> canonical origin
+	allowedSchemes    map[string]bool   // schemes accepted by the any scheme origins, nil means any scheme
+	maxAgeByOrigin    []originMaxAge
+	deprecatedOrigins []originWarning
+	// the allowed headers by requested method, they replace allowedHeaders
+	allowedHeadersByMethod map[string]methodHeaders
 	// the next tho maps are used to speedup match of headers and methods
 	allowedMethods map[string]bool
 	allowedHeaders map[string]bool
 	// the next two variable store the original strings, header can be in any case, but the match is byte-case-insensitive
-	allowedHeadersString string
-	allowedMethodsString string
-	hostName             string
-	maxAge               string
-	exposedHeaders       string
-	exposeHeader         bool
-	allowAllOrigins      bool
-	allowAllHeaders      bool
-	allowCredentials     bool
-	forwardRequest       bool
+	allowedHeadersString           string
+	allowedMethodsString           string
+	methodsProvider                func(r *http.Request) []string
+	hostName                       string
+	maxAge                         string
+	exposedHeaders                 string
+	maxResponseHeaderBytes         int
+	maxOriginLength                int
+	preflightMethodRejectStatus    int
+	preflightHeaderRejectStatus    int
+	exposeHeader                   bool
+	allowAllHeaders                bool
+	allowCredentials               bool
+	forwardRequest                 bool
+	forwardRequestPaths            []string
+	stripOriginOnForward           bool
+	forwardDisallowedPreflight     bool
+	suppressHeadersOnServerError   bool
+	defaultOptionsHandler          http.Handler
+	crossOriginResourcePolicy      string
+	silentReject                   bool
+	reportOnly                     bool
+	reportURI                      string
+	reportClient                   *http.Client
+	reportSlots                    chan struct{} // the reports being sent
+	metrics                        Metrics
+	preflights                     *preflightTracker
+	allowHeadersOnlyIfRequested    bool
+	rejectSimpleMethodPreflight    bool
+	correlationHeader              string
+	strictRequestHeaders           bool
+	privateCacheOnReflect          bool
+	allowPrivateNetwork            bool
+	privateNetworkOrigins          *origins
+	credentialsOnlyForExactOrigins bool
+	credentialsFunc                func(r *http.Request) bool
+	maxRequestMethodLen            int
+	canonicalVary                  bool
+	fallbackToReferer              bool
+	forbidCredentials              bool
+	requireTLSForCredentials       bool
+	optionsAlways200               bool
+	tracer                         Tracer
+	skipSameOrigin                 bool
+	downgrades                     *downgradeTracker
+	omitSameOriginVary             bool
+	useFetchMetadata               bool
+	methodAdvertisement            MethodAdvertisement
+	requireCORSFetchMode           bool
+	lowerCaseReflectedHost         bool
+	normalizeReflectedOrigin       bool
+	originTemplate                 string
+	maxOrigins                     int
+	now                            func() time.Time
+	maxOriginPatternSize           int
+	warmupMatchers                 bool
+	logLevel                       LogLevel
+	strictMethodCase               bool
+	originTokenHeader              string
+	verifyOriginToken              func(token, origin string) bool
 }
 
 // allowed build maps of allowed values
@@ -123,7 +1022,10 @@ func allowed(allowed [][]byte) (m map[string]bool) {
 	return m
 }
 
-// toLowerCase convert s to lower case, s must contains only ASCII chars
+// toLowerCase convert the ASCII letters of s to lower case, in place.
+// The other bytes are left untouched, so a multibyte UTF-8 sequence (all its bytes are >= 0x80) is never corrupted,
+// but non ASCII letters aren't converted. It's meant for header names, origins are never lower cased with it.
+// s must be owned by the caller, never pass a slice shared with a header value
 func toLowerCase(s []byte) []byte {
 	for i, c := range s {
 		if 'A' <= c && c <= 'Z' {
@@ -194,6 +1096,46 @@ func normalizeHeaders(headers string) (ss [][]byte) {
 	return ss
 }
 
+// truncateList truncate the comma separated list after the last item that fits into max bytes
+func truncateList(list string, max int) string {
+	if len(list) <= max {
+		return list
+	}
+
+	cut := strings.LastIndexByte(list[:max+1], ',')
+	if cut < 0 {
+		return ""
+	}
+
+	return strings.TrimRight(list[:cut], " ")
+}
+
+// remoteAddr return the client address to log, "unknown" if it's empty
+func remoteAddr(r *http.Request) string {
+	if r.RemoteAddr == "" {
+		return "unknown"
+	}
+
+	return r.RemoteAddr
+}
+
+// trimHeaders return the comma separated list of headers with spaces trimmed and without empty items, preserving order and case
+func trimHeaders(headers string) string {
+	var sb strings.Builder
+	for _, h := range strings.Split(headers, ",") {
+		h = strings.Trim(h, " \t")
+		if len(h) == 0 {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(h)
+	}
+
+	return sb.String()
+}
+
 // logInit convenient log wrapper initializer
 func logInit(logger *log.Logger) func(format string, v ...interface{}) {
 	if logger == nil {
@@ -204,6 +1146,35 @@ func logInit(logger *log.Logger) func(format string, v ...interface{}) {
 	}
 }
 
+// logRequest log a line of the request r at level, with the correlation id if any
+func (c *cors) logRequest(r *http.Request, level LogLevel, format string, v ...interface{}) {
+	c.logCorrelated(c.correlationID(r), level, format, v...)
+}
+
+// correlationID return the correlation id of the request r, "" if it hasn't one
+func (c *cors) correlationID(r *http.Request) string {
+	if c.correlationHeader == "" {
+		return ""
+	}
+
+	return r.Header.Get(c.correlationHeader)
+}
+
+// logCorrelated log a line at level, with the correlation id if not empty.
+// Unlike logRequest it doesn't read the request, so it's safe after the handler returned
+func (c *cors) logCorrelated(id string, level LogLevel, format string, v ...interface{}) {
+	if level > c.logLevel {
+		return
+	}
+
+	if id != "" {
+		c.logWrap("[%s] "+format, append([]interface{}{id}, v...)...)
+		return
+	}
+
+	c.logWrap(format, v...)
+}
+
 // initialize initialize the cors filter
 func initialize(config Config) (c *cors) {
 	// assume some dafault
@@ -212,34 +1183,171 @@ func initialize(config Config) (c *cors) {
 		allowedMethodsString: DefaultAllowedMethods,
 		allowedHeaders:       allowed(normalizeHeaders(DefaultAllowedHeaders)),
 		allowedHeadersString: DefaultAllowedHeaders,
-		allowAllOrigins:      true,
 		maxAge:               "1800",
+		maxRequestMethodLen:  DefaultMaxRequestMethodLen,
+		maxOriginLength:      DefaultMaxOriginLength,
+
+		preflightMethodRejectStatus: http.StatusMethodNotAllowed,
+		preflightHeaderRejectStatus: http.StatusForbidden,
 	}
 
 	c.logWrap = logInit(config.Logger)
-	c.forwardRequest = config.ForwardRequest
-
-	if len(config.AllowedOrigins) > 0 && config.AllowedOrigins != "*" {
+	c.done = make(chan struct{})
 
+	c.now = time.Now
+	if config.nowFunc != nil {
+		c.now = config.nowFunc
+	}
+	c.correlationHeader = config.CorrelationHeader
+	c.logLevel = config.LogLevel
+	c.forwardRequest = config.ForwardRequest
+	for _, p := range config.ForwardRequestPaths {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			c.forwardRequestPaths = append(c.forwardRequestPaths, p)
+		}
+	}
+	c.stripOriginOnForward = config.StripOriginOnForward
+	c.forwardDisallowedPreflight = config.ForwardDisallowedPreflight
+	c.suppressHeadersOnServerError = config.SuppressHeadersOnServerError
+	c.defaultOptionsHandler = config.DefaultOptionsHandler
+	if len(config.CrossOriginResourcePolicy) > 0 {
+		if isCrossOriginResourcePolicy(config.CrossOriginResourcePolicy) {
+			c.crossOriginResourcePolicy = config.CrossOriginResourcePolicy
+		} else {
+			c.logWrap("Error: ignore CrossOriginResourcePolicy = %q, it must be %s, %s or %s", config.CrossOriginResourcePolicy, CORPSameOrigin, CORPSameSite, CORPCrossOrigin)
+		}
+	}
+	c.methodsProvider = config.MethodsProvider
+	c.silentReject = config.SilentReject
+	c.reportOnly = config.ReportOnly
+	if config.ReportOnly && len(config.ReportURI) > 0 {
+		c.reportURI = config.ReportURI
+		c.reportClient = &http.Client{Timeout: reportTimeout}
+		c.reportSlots = make(chan struct{}, maxPendingReports)
+	} else if len(config.ReportURI) > 0 {
+		c.logWrap("Warning: ReportURI is useless without ReportOnly.")
+	}
+	c.rejectSimpleMethodPreflight = config.RejectSimpleMethodPreflight
+	c.strictRequestHeaders = config.StrictRequestHeaders
+	c.privateCacheOnReflect = config.PrivateCacheOnReflect
+	c.allowPrivateNetwork = config.AllowPrivateNetwork
+	c.credentialsOnlyForExactOrigins = config.CredentialsOnlyForExactOrigins
+	c.allowHeadersOnlyIfRequested = config.AllowHeadersOnlyIfRequested
+	c.canonicalVary = config.CanonicalVary
+	c.fallbackToReferer = config.FallbackToReferer
+	c.skipSameOrigin = config.SkipSameOrigin
+	if config.RejectSchemeDowngrade {
+		c.downgrades = newDowngradeTracker()
+	}
+	if config.OmitSameOriginVary && !config.SkipSameOrigin {
+		c.logWrap("Warning: OmitSameOriginVary is useless without SkipSameOrigin.")
+	}
+	c.omitSameOriginVary = config.SkipSameOrigin && config.OmitSameOriginVary
+	c.useFetchMetadata = config.UseFetchMetadata
+	switch config.MethodAdvertisement {
+	case AdvertiseFull:
+		if config.AdvertiseOptions {
+			c.methodAdvertisement = AdvertiseFullPlusOptions
+		}
+	case AdvertiseReflectRequest, AdvertiseFullPlusOptions:
+		c.methodAdvertisement = config.MethodAdvertisement
+		if config.AdvertiseOptions {
+			c.logWrap("Warning: ignore AdvertiseOptions, MethodAdvertisement = %d is set.", config.MethodAdvertisement)
+		}
+	default:
+		c.logWrap("Error: ignore MethodAdvertisement = %d, unknown strategy", config.MethodAdvertisement)
+	}
+	c.requireCORSFetchMode = config.RequireCORSFetchMode
+	c.lowerCaseReflectedHost = config.LowerCaseReflectedHost
+	c.normalizeReflectedOrigin = config.NormalizeReflectedOrigin
+	if len(config.OriginTemplate) > 0 && config.OriginTemplate != OriginTemplateOrigin {
+		if strings.Contains(config.OriginTemplate, OriginTemplateOrigin) {
+			c.originTemplate = config.OriginTemplate
+		} else {
+			c.logWrap("Error: ignore OriginTemplate = %q, it must contain %s", config.OriginTemplate, OriginTemplateOrigin)
+		}
+	}
+	c.optionsAlways200 = config.OptionsAlways200
+	c.tracer = config.Tracer
+
+	if config.MaxOriginLength > 0 {
+		c.maxOriginLength = config.MaxOriginLength
+	}
+
+	if isRejectStatus(config.PreflightMethodRejectStatus) {
+		c.preflightMethodRejectStatus = config.PreflightMethodRejectStatus
+	} else if config.PreflightMethodRejectStatus != 0 {
+		c.logWrap("Error: ignore PreflightMethodRejectStatus = %d, it must be a 4xx or 5xx status code", config.PreflightMethodRejectStatus)
+	}
+
+	if isRejectStatus(config.PreflightHeaderRejectStatus) {
+		c.preflightHeaderRejectStatus = config.PreflightHeaderRejectStatus
+	} else if config.PreflightHeaderRejectStatus != 0 {
+		c.logWrap("Error: ignore PreflightHeaderRejectStatus = %d, it must be a 4xx or 5xx status code", config.PreflightHeaderRejectStatus)
+	}
+
+	if config.MaxRequestMethodLen > 0 {
+		c.maxRequestMethodLen = config.MaxRequestMethodLen
+	}
+
+	c.maxOrigins = config.MaxOrigins
+	c.originAliases = config.OriginAliases
+	c.strictMethodCase = config.StrictMethodCase
+	c.originTokenHeader = config.OriginTokenHeader
+	c.verifyOriginToken = config.VerifyOriginToken
+	c.warmupMatchers = config.WarmupMatchers
+	c.maxOriginPatternSize = DefaultMaxOriginPatternSize
+	if config.MaxOriginPatternSize > 0 {
+		c.maxOriginPatternSize = config.MaxOriginPatternSize
+	}
+
+	// compiled like AllowedOrigins, with the same pattern size limit and warmup
+	if len(strings.TrimSpace(config.PrivateNetworkOrigins)) > 0 {
+		list := strings.Split(config.PrivateNetworkOrigins, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		c.allowPrivateNetwork = true
+		c.privateNetworkOrigins = c.compileOrigins(list)
+	}
+
+	c.origins = c.compileOrigins([]string{OriginMatchAll})
+	if len(config.AllowedOrigins) > 0 && config.AllowedOrigins != "*" {
 		// origin match are key sensitive
-		origins := strings.Split(config.AllowedOrigins, ",")
-
-		// different type of origins...
-		for _, o := range origins {
-			if !strings.ContainsAny(o, "*") {
-				c.allowedStaticOrigins = append(c.allowedStaticOrigins, o)
-			} else if strings.Index(o, "*.") == 0 {
-				c.allowedSuffixOrigins = append(c.allowedSuffixOrigins, o[2:])
-			} else if strings.Count(o, "*") > 0 || strings.Count(o, "?") > 0 {
-				p := regexp.QuoteMeta(strings.TrimSpace(o))
-				p = strings.Replace(p, "\\*", ".*", -1)
-				p = strings.Replace(p, "\\?", ".", -1)
-				r := regexp.MustCompile(p)
-				c.allowedRegexOrigins = append(c.allowedRegexOrigins, r)
-			}
+		list := strings.Split(config.AllowedOrigins, ",")
+		if c.maxOrigins > 0 && len(list) > c.maxOrigins {
+			// don't compile a pathological list, deny all origins instead
+			c.logWrap("Error: %d AllowedOrigins exceed MaxOrigins = %d, no origin is allowed", len(list), c.maxOrigins)
+			list = nil
 		}
+		c.origins = c.compileOrigins(list)
+	}
 
-		c.allowAllOrigins = false
+	if config.AllowOriginFunc != nil || len(config.OriginMatchers) > 0 || config.OriginClaimVerifier != nil {
+		c.originFunc = config.AllowOriginFunc
+		c.originMatchers = config.OriginMatchers
+		c.claimVerifier = config.OriginClaimVerifier
+		c.claimsOnPreflight = config.VerifyClaimsOnPreflight
+		c.requireBoth = config.RequireBothMatchers && config.AllowOriginFunc != nil
+		if c.requireBoth && len(config.AllowedOrigins) == 0 && config.OriginsLoader == nil {
+			c.logWrap("Warning: RequireBothMatchers without AllowedOrigins, no origin is allowed.")
+		}
+		if len(config.AllowedOrigins) == 0 {
+			// only the function and the matchers are consulted
+			c.origins = c.compileOrigins(nil)
+		}
+	}
+
+	if config.OriginsLoader != nil && len(config.AllowedOrigins) == 0 {
+		// start from no origin, a loader failure must not allow them all
+		c.origins = c.compileOrigins(nil)
+	}
+
+	if len(config.AllowedSchemes) > 0 {
+		c.allowedSchemes = make(map[string]bool)
+		for _, scheme := range strings.Split(config.AllowedSchemes, ",") {
+			c.allowedSchemes[strings.ToLower(strings.TrimSpace(scheme))] = true
+		}
 	}
 
 	if len(config.AllowedMethods) > 0 {
@@ -251,6 +1359,9 @@ func initialize(config Config) (c *cors) {
 		if config.AllowedHeaders == strings.TrimSpace("*") {
 			c.allowAllHeaders = true
 			c.allowedHeadersString = "*"
+		} else if strings.TrimSpace(config.AllowedHeaders) == NoAllowedHeaders {
+			c.allowedHeaders = make(map[string]bool)
+			c.allowedHeadersString = ""
 		} else {
 			headers := normalizeHeaders(config.AllowedHeaders)
 			c.allowedHeaders = allowed(headers)
@@ -258,37 +1369,209 @@ func initialize(config Config) (c *cors) {
 		}
 	}
 
+	if config.LegacyAJAXCompat && !c.allowAllHeaders {
+		for _, h := range normalizeHeaders(LegacyAJAXHeaders) {
+			c.allowedHeaders[string(h)] = true
+		}
+		if len(c.allowedHeadersString) > 0 {
+			c.allowedHeadersString += "," + LegacyAJAXHeaders
+		} else {
+			c.allowedHeadersString = LegacyAJAXHeaders
+		}
+	}
+
+	if config.LowerCaseAllowedHeaders {
+		c.allowedHeadersString = strings.ToLower(c.allowedHeadersString)
+	}
+
+	if config.OmitSafelistedHeaders && !c.allowAllHeaders {
+		c.allowedHeadersString = withoutSafelistedHeaders(c.allowedHeadersString)
+	}
+
+	if len(config.AllowedHeadersByMethod) > 0 {
+		c.allowedHeadersByMethod = make(map[string]methodHeaders, len(config.AllowedHeadersByMethod))
+		for method, headers := range config.AllowedHeadersByMethod {
+			if strings.TrimSpace(headers) == "*" {
+				c.logWrap("Error: ignore AllowedHeadersByMethod[%q], \"*\" isn't supported, use AllowedHeaders", method)
+				continue
+			}
+			list := strings.TrimSpace(headers)
+			if config.LowerCaseAllowedHeaders {
+				list = strings.ToLower(list)
+			}
+			if config.OmitSafelistedHeaders {
+				list = withoutSafelistedHeaders(list)
+			}
+			c.allowedHeadersByMethod[strings.ToUpper(strings.TrimSpace(method))] = methodHeaders{
+				allowed: allowed(normalizeHeaders(headers)),
+				list:    list,
+			}
+		}
+	}
+
 	if config.MaxAge > 0 {
 		c.maxAge = strconv.Itoa(config.MaxAge)
+
+		// MaxAge applies only to preflight responses, that need the OPTIONS method
+		if !c.isMethodAllowed(http.MethodOptions) {
+			c.logWrap("MaxAge = %d is useless, %s isn't an allowed method so preflight requests can't be handled.", config.MaxAge, http.MethodOptions)
+		}
+	}
+
+	if len(config.MaxAgeByOrigin) > 0 {
+		keys := make([]string, 0, len(config.MaxAgeByOrigin))
+		for k := range config.MaxAgeByOrigin {
+			keys = append(keys, k)
+		}
+		sortOriginKeys(keys)
+
+		for _, k := range keys {
+			c.maxAgeByOrigin = append(c.maxAgeByOrigin, originMaxAge{
+				origins: c.compileOrigins([]string{k}),
+				maxAge:  strconv.Itoa(config.MaxAgeByOrigin[k]),
+			})
+		}
+	}
+
+	if len(config.DeprecatedOrigins) > 0 {
+		keys := make([]string, 0, len(config.DeprecatedOrigins))
+		for k := range config.DeprecatedOrigins {
+			keys = append(keys, k)
+		}
+		sortOriginKeys(keys)
+
+		for _, k := range keys {
+			c.deprecatedOrigins = append(c.deprecatedOrigins, originWarning{
+				origins: c.compileOrigins([]string{k}),
+				warning: fmt.Sprintf("299 - %q", config.DeprecatedOrigins[k]),
+			})
+		}
+	}
+
+	if config.Metrics != nil {
+		c.metrics = config.Metrics
+		maxAge := config.MaxAge
+		if maxAge <= 0 {
+			maxAge = DefaultMaxAge
+		}
+		c.preflights = newPreflightTracker(time.Duration(maxAge)*time.Second, c.now)
+		c.preflights.cache = config.Cache
+	} else if config.Cache != nil {
+		c.logWrap("Warning: Cache is useless without Metrics, it's ignored.")
 	}
 
 	if len(config.ExposedHeaders) > 0 {
-		c.exposedHeaders = config.ExposedHeaders
+		c.exposedHeaders = c.checkExposedHeaders(config.ExposedHeaders)
+		c.exposeHeader = len(c.exposedHeaders) > 0
+	}
+
+	if config.ExposeSafelistedHeaders {
+		if c.exposeHeader {
+			c.exposedHeaders += "," + SafelistedResponseHeaders
+		} else {
+			c.exposedHeaders = SafelistedResponseHeaders
+		}
 		c.exposeHeader = true
 	}
 
-	if config.AllowCredentials && c.allowAllOrigins {
+	if config.MaxResponseHeaderBytes > 0 {
+		c.maxResponseHeaderBytes = config.MaxResponseHeaderBytes
+		if len(c.allowedHeadersString) > c.maxResponseHeaderBytes && !c.allowAllHeaders {
+			c.allowedHeadersString = truncateList(c.allowedHeadersString, c.maxResponseHeaderBytes)
+			c.logWrap("Warning: the allowed headers exceed MaxResponseHeaderBytes = %d, Access-Control-Allow-Headers is truncated to %q.", c.maxResponseHeaderBytes, c.allowedHeadersString)
+		}
+		if len(c.exposedHeaders) > c.maxResponseHeaderBytes {
+			c.exposedHeaders = truncateList(c.exposedHeaders, c.maxResponseHeaderBytes)
+			c.exposeHeader = len(c.exposedHeaders) > 0
+			c.logWrap("Warning: the exposed headers exceed MaxResponseHeaderBytes = %d, Access-Control-Expose-Headers is truncated to %q.", c.maxResponseHeaderBytes, c.exposedHeaders)
+		}
+	}
+
+	c.requireTLSForCredentials = config.RequireTLSForCredentials
+
+	if config.ForbidCredentials {
+		c.forbidCredentials = true
+		if config.AllowCredentials || config.AllowCredentialsFunc != nil {
+			c.logWrap("Ignore AllowCredentials and AllowCredentialsFunc, ForbidCredentials = true.")
+		}
+	} else if config.AllowCredentialsFunc != nil && c.getOrigins().allowAllOrigins {
+		c.logWrap("Ignore AllowCredentialsFunc. It's a security issue set up AllowOrigin==* and AllowCredientials.")
+	} else if config.AllowCredentialsFunc != nil {
+		c.credentialsFunc = config.AllowCredentialsFunc
+	} else if config.AllowCredentials && c.getOrigins().allowAllOrigins {
 		c.logWrap("Ignore AllowCredentials = true. It's a security issue set up AllowOrigin==* and AllowCredientials==true.")
 	} else {
 		c.allowCredentials = config.AllowCredentials
 	}
 
-	c.logWrap("Filter configuration [%s]", c)
+	// the credentials are decided on the configured origins, the loaded ones can't enable all origins with credentials
+	if config.OriginsLoader != nil {
+		c.originsLoader = config.OriginsLoader
+		c.loadOrigins()
+
+		if config.OriginsRefreshInterval > 0 {
+			go c.refreshOrigins(config.RefreshContext, config.OriginsRefreshInterval)
+		}
+	}
+
+	if c.allowAllHeaders && (c.allowCredentials || c.credentialsFunc != nil) {
+		c.logWrap("Warning: AllowedHeaders = \"*\" with credentials, \"*\" would be a literal header name, the requested headers are allowed one by one.")
+	}
+
+	c.logWrap("Filter configuration %s", c.configFields())
 	return c
 }
 
-func (c *cors) String() string {
-	var s string
+// configFields return the main configuration as space separated key=value pairs, e.g. to be parsed from the startup log.
+// The values with spaces, quotes or equal signs are quoted
+func (c *cors) configFields() string {
+	credentials := c.allowCredentials || c.credentialsFunc != nil
+	headers := "*"
+	if !c.allowAllHeaders {
+		headers = sortedKeys(c.allowedHeaders)
+	}
 
-	if c.allowAllOrigins {
-		s += "AllowedOrigins: *;"
-	} else {
-		s += "AllowedOrigins: "
-		for _, r := range c.allowedRegexOrigins {
-			s += r.String() + ","
+	fields := []string{
+		"version", Version(),
+		"origins", strings.Join(c.getOrigins().patterns(), ","),
+		"methods", sortedKeys(c.allowedMethods),
+		"headers", headers,
+		"exposed_headers", c.exposedHeaders,
+		"credentials", strconv.FormatBool(credentials),
+		"max_age", c.maxAge,
+		"forward_request", strconv.FormatBool(c.forwardRequest),
+	}
+
+	var s []string
+	for i := 0; i < len(fields); i += 2 {
+		v := fields[i+1]
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		s = append(s, fields[i]+"="+v)
+	}
+
+	return strings.Join(s, " ")
+}
+
+// sortedKeys return the sorted, comma separated, keys of m with a true value
+func sortedKeys(m map[string]bool) string {
+	var keys []string
+	for k, v := range m {
+		if v {
+			keys = append(keys, k)
 		}
-		s = s[:len(s)-1] + ";"
 	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
+
+func (c *cors) String() string {
+	var s string
+
+	s += "Version: " + Version() + "; "
+	s += "AllowedOrigins: " + strings.Join(c.getOrigins().patterns(), ",") + ";"
 
 	s += " AllowedHeaders: "
 	for k, v := range c.allowedHeaders {
@@ -325,26 +1608,412 @@ func (c *cors) String() string {
 	return s
 }
 
+// getOrigins return the allowed origins currently in use
+func (c *cors) getOrigins() *origins {
+	c.originsMu.RLock()
+	defer c.originsMu.RUnlock()
+
+	return c.origins
+}
+
+// loadOrigins replace the allowed origins with the ones returned by the origins loader, on error the current origins are kept
+func (c *cors) loadOrigins() {
+	list, err := c.originsLoader()
+	if err != nil {
+		c.logWrap("Unable to load the allowed origins, keep the current ones: %s", err)
+		return
+	}
+
+	if c.maxOrigins > 0 && len(list) > c.maxOrigins {
+		c.logWrap("Ignore the loaded origins, %d origins exceed MaxOrigins = %d", len(list), c.maxOrigins)
+		return
+	}
+
+	o := c.compileOrigins(list)
+	if o.allowAllOrigins && (c.allowCredentials || c.credentialsFunc != nil) {
+		c.logWrap("Ignore the loaded origins, it's a security issue set up AllowOrigin==* and AllowCredientials==true.")
+		return
+	}
+
+	c.originsMu.Lock()
+	c.origins = o
+	c.originsMu.Unlock()
+
+	c.logWrap("Loaded allowed origins %v", list)
+}
+
+// refreshOrigins reload the origins every interval, until ctx is done or the filter is closed
+func (c *cors) refreshOrigins(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var ctxDone <-chan struct{}
+	if ctx != nil {
+		ctxDone = ctx.Done()
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			c.loadOrigins()
+		case <-c.done:
+			return
+		case <-ctxDone:
+			return
+		}
+	}
+}
+
+// close stop the background goroutines of the filter
+func (c *cors) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// serverOrigin return the origin of the server that received the request
+func serverOrigin(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+
+	return "http://" + r.Host
+}
+
+// hasValue return true if values contains v
+func hasValue(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSuffixFold like strings.HasSuffix, but case insensitive
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// isCrossOriginResourcePolicy return true if policy is a valid Cross-Origin-Resource-Policy value
+func isCrossOriginResourcePolicy(policy string) bool {
+	return policy == CORPSameOrigin || policy == CORPSameSite || policy == CORPCrossOrigin
+}
+
+// refererOrigin return the origin derived from the Referer header, or "" if it's missing, invalid or has the server origin
+func refererOrigin(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	if strings.EqualFold(origin, serverOrigin(r)) {
+		return ""
+	}
+
+	return origin
+}
+
 // isOriginAllowed return true if the origin is allowed
-func (c *cors) isOriginAllowed(origin string) bool {
-	if c.allowAllOrigins {
-		return true
+func (c *cors) isOriginAllowed(origin string, r *http.Request) bool {
+	return c.matchOrigin(parseOrigin(origin), r) != matchNone
+}
+
+// matchOrigin return how the origin matched the allowed origins
+func (c *cors) matchOrigin(origin parsedOrigin, r *http.Request) originMatch {
+	if c.requireBoth {
+		// second stage, only for the origins that pass the first one
+		if m := c.matchAllowedOrigins(origin, r); m != matchNone && c.originFunc(origin.raw) {
+			return m
+		}
+		return matchNone
 	}
 
-	for _, o := range c.allowedStaticOrigins {
-		if o == origin {
+	if m := c.matchAllowedOrigins(origin, r); m != matchNone {
+		return m
+	}
+
+	if c.originFunc != nil && c.originFunc(origin.raw) {
+		return matchFunc
+	}
+
+	for _, m := range c.originMatchers {
+		if m.Match(origin.raw) {
+			return matchFunc
+		}
+	}
+
+	if c.claimVerifier != nil && r != nil {
+		// the claims aren't sent with a preflight request, they are verified on the actual request
+		if r.Method == http.MethodOptions && !c.claimsOnPreflight {
+			return matchFunc
+		}
+		if c.matchClaims(origin, r) {
+			return matchFunc
+		}
+	}
+
+	return matchNone
+}
+
+// matchClaims return true if the origin is one of the origins returned by the claim verifier for r
+func (c *cors) matchClaims(origin parsedOrigin, r *http.Request) bool {
+	allowed, err := c.claimVerifier(r)
+	if err != nil {
+		c.logRequest(r, LogWarn, "Origin claims of %s not verified: %v", remoteAddr(r), err)
+		return false
+	}
+
+	for _, a := range allowed {
+		if origin.sameOrigin(parseOrigin(strings.TrimSpace(a))) {
 			return true
 		}
 	}
 
-	for _, o := range c.allowedSuffixOrigins {
-		if len(origin) >= len(o) && strings.HasSuffix(origin, o) {
+	return false
+}
+
+// matchAllowedOrigins match the origin against the aliases, the allowed origins and $self
+func (c *cors) matchAllowedOrigins(origin parsedOrigin, r *http.Request) originMatch {
+	if _, ok := c.originAliases[origin.raw]; ok {
+		return matchStatic
+	}
+
+	o := c.getOrigins()
+	if m := o.match(origin, c.allowedSchemes); m != matchNone {
+		return m
+	}
+
+	if o.allowSelf && r != nil && origin.sameOrigin(parseOrigin(serverOrigin(r))) {
+		return matchSelf
+	}
+
+	return matchNone
+}
+
+// credentialsAllowed return true if the credentials header must be emitted for the request, whose origin matched with m
+func (c *cors) credentialsAllowed(m originMatch, r *http.Request) bool {
+	// an origin served in report only mode
+	if c.forbidCredentials || m == matchNone {
+		return false
+	}
+
+	if c.credentialsFunc != nil {
+		if !c.credentialsFunc(r) {
+			return false
+		}
+	} else if !c.allowCredentials {
+		return false
+	}
+
+	if c.credentialsOnlyForExactOrigins && m != matchStatic && m != matchSelf {
+		return false
+	}
+
+	if c.requireTLSForCredentials && r.TLS == nil {
+		c.logRequest(r, LogWarn, "Credentials not allowed for the request from %s, it isn't over TLS", remoteAddr(r))
+		return false
+	}
+
+	return true
+}
+
+// reflectionVaries return true if the Access-Control-Allow-Origin can change with the request origin,
+// i.e. the allowed origins aren't a single static origin, or any origin is reflected in report only mode
+func (c *cors) reflectionVaries() bool {
+	o := c.getOrigins()
+	single := len(o.allowedStaticOrigins) == 1 && !o.allowAllOrigins && !o.allowSelf &&
+		len(o.allowedSuffixOrigins)+len(o.allowedTLDOrigins)+len(o.allowedApexOrigins)+len(o.allowedRegexOrigins)+
+			len(o.allowedAnySchemeOrigins)+len(o.allowedAnySchemeSuffixOrigins)+len(o.allowedPortRanges) == 0
+
+	return !single || c.reportOnly || c.originFunc != nil || len(c.originMatchers) > 0 || c.claimVerifier != nil || len(c.originAliases) > 0
+}
+
+// privateCacheControl downgrade the Cache-Control header to private, dropping the shared cache directives.
+// It's left untouched if the response isn't stored at all, or it's already private
+func privateCacheControl(h http.Header) {
+	var directives []string
+	for _, v := range h[CacheControlHeader] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			name := strings.ToLower(d)
+			if name == "no-store" || name == "private" {
+				return
+			}
+			if d == "" || name == "public" || strings.HasPrefix(name, "s-maxage") {
+				continue
+			}
+			directives = append(directives, d)
+		}
+	}
+
+	h.Set(CacheControlHeader, strings.Join(append([]string{"private"}, directives...), ", "))
+}
+
+// sortOriginKeys sort the origins keys of MaxAgeByOrigin and DeprecatedOrigins: static origins first, then the others in lexical order
+func sortOriginKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := !strings.ContainsAny(keys[i], "*?"), !strings.ContainsAny(keys[j], "*?")
+		if si != sj {
+			return si
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// originWarning return the Warning header value for a deprecated origin, empty if the origin isn't deprecated
+func (c *cors) originWarning(origin parsedOrigin) string {
+	for _, d := range c.deprecatedOrigins {
+		if d.origins.isAllowed(origin, c.allowedSchemes) {
+			return d.warning
+		}
+	}
+
+	return ""
+}
+
+// originMaxAge return the MaxAge for the origin
+func (c *cors) originMaxAge(origin parsedOrigin) string {
+	for _, m := range c.maxAgeByOrigin {
+		if m.origins.isAllowed(origin, c.allowedSchemes) {
+			return m.maxAge
+		}
+	}
+
+	return c.maxAge
+}
+
+// preflightMethods return true if the preflight requested method is allowed, and the list of methods to advertise.
+// With MethodsProvider, they are the provider methods that are allowed too, as for the actual request
+func (c *cors) preflightMethods(r *http.Request, method string) (bool, string) {
+	if c.methodsProvider == nil {
+		return c.isMethodAllowed(method), c.allowedMethodsString
+	}
+
+	methods := c.resourceMethods(r)
+	for _, m := range strings.Split(methods, ",") {
+		if strings.EqualFold(m, method) {
+			return true, methods
+		}
+	}
+
+	return false, ""
+}
+
+// resourceMethods return the upper case comma separated methods valid for the request: the allowed methods,
+// restricted to the MethodsProvider ones if it's set. It's the Allow header of a 405 response
+func (c *cors) resourceMethods(r *http.Request) string {
+	methods := strings.Split(c.allowedMethodsString, ",")
+	if c.methodsProvider != nil {
+		methods = c.methodsProvider(r)
+	}
+
+	valid := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" && c.isMethodAllowed(m) {
+			valid = append(valid, m)
+		}
+	}
+
+	return strings.Join(valid, ",")
+}
+
+// canonicalOrigin return the origin without path, query and fragment, i.e. scheme://host[:port]
+func canonicalOrigin(origin string) string {
+	i := strings.Index(origin, "://")
+	if i < 0 {
+		return origin
+	}
+
+	if j := strings.IndexAny(origin[i+len("://"):], "/?#"); j >= 0 {
+		return origin[:i+len("://")+j]
+	}
+
+	return origin
+}
+
+// withOptions return the comma separated methods list with OPTIONS appended, if missing
+func withOptions(methods string) string {
+	for _, m := range strings.Split(methods, ",") {
+		if strings.EqualFold(strings.TrimSpace(m), http.MethodOptions) {
+			return methods
+		}
+	}
+
+	if methods == "" {
+		return http.MethodOptions
+	}
+
+	return methods + "," + http.MethodOptions
+}
+
+// isStateChangingMethod return true if method is a state changing method
+func isStateChangingMethod(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
+
+// mayBeNavigation return true if r can be a navigation, e.g. a link followed from another site: by its Sec-Fetch-Mode,
+// or, if missing, by its method
+func mayBeNavigation(r *http.Request) bool {
+	if mode := r.Header.Get(SecFetchModeHeader); mode != "" {
+		return mode == "navigate"
+	}
+	return !isStateChangingMethod(r.Method)
+}
+
+// isSimpleMethod return true if method is a CORS simple method
+func isSimpleMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+// withoutSafelistedHeaders return the comma separated list of headers without the always CORS-safelisted ones
+func withoutSafelistedHeaders(list string) string {
+	var headers []string
+	for _, h := range strings.Split(list, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" || !hasNonSimpleHeaders(h) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+
+	return strings.Join(headers, ",")
+}
+
+// hasNonSimpleHeaders return true if the request headers contain an header that isn't always CORS-safelisted.
+// Content-Type is CORS-safelisted only for some values, so when it's requested it's a non simple header
+func hasNonSimpleHeaders(reqHeaders string) bool {
+	for _, header := range normalizeHeaders(reqHeaders) {
+		switch string(header) {
+		case "accept", "accept-language", "content-language":
+		default:
 			return true
 		}
 	}
 
-	for _, o := range c.allowedRegexOrigins {
-		if o.MatchString(origin) {
+	return false
+}
+
+// hasForbiddenHeaders return true if the request headers contain a forbidden header name
+func hasForbiddenHeaders(reqHeaders string) bool {
+	for _, header := range normalizeHeaders(reqHeaders) {
+		if forbiddenHeaders[string(header)] || bytes.HasPrefix(header, []byte("proxy-")) || bytes.HasPrefix(header, []byte("sec-")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasWildcardHeader return true if the request headers contain the "*" header name
+func hasWildcardHeader(reqHeaders string) bool {
+	if strings.IndexByte(reqHeaders, '*') < 0 {
+		return false
+	}
+
+	for _, header := range strings.Split(reqHeaders, ",") {
+		if strings.TrimSpace(header) == "*" {
 			return true
 		}
 	}
@@ -352,137 +2021,450 @@ func (c *cors) isOriginAllowed(origin string) bool {
 	return false
 }
 
+// isTLD return true if s is a single domain label, optionally followed by a port (e.g. "com" or "de:8080")
+func isTLD(s string) bool {
+	i := 0
+	for ; i < len(s) && s[i] != ':'; i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+			return false
+		}
+	}
+
+	if i == 0 {
+		return false
+	}
+
+	if i == len(s) {
+		return true
+	}
+
+	// check the port
+	if i == len(s)-1 {
+		return false
+	}
+	for i++; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
 // isMethodAllowed return true if the method is allowed
 func (c *cors) isMethodAllowed(method string) bool {
 	return c.allowedMethods[method]
 }
 
-// areReqHeadersAllowed return true if the request headers are allowed
+// areReqHeadersAllowed return true if the request headers are allowed.
 func (c *cors) areReqHeadersAllowed(reqHeaders string) bool {
-	if c.allowAllHeaders || len(reqHeaders) == 0 {
+	if c.allowAllHeaders {
 		return true
 	}
 
-	for _, header := range normalizeHeaders(reqHeaders) {
-		// check if header are allowed
-		// The compiler recognizes m[string(byteSlice)] as a special case, no conversion happens
-		if !c.allowedHeaders[string(header)] {
-			return false
+	return headersAllowed(c.allowedHeaders, reqHeaders)
+}
+
+// headersAllowed return true if all the request headers are in allowed.
+// The request headers are scanned in place, each header name is lowercased into a stack buffer and matched against the allowed map,
+// so no allocation happens for header names shorter than maxHeaderNameLen
+func headersAllowed(allowed map[string]bool, reqHeaders string) bool {
+	const sep byte = ',' // headers separator
+
+	if len(reqHeaders) == 0 {
+		return true
+	}
+
+	var buf [maxHeaderNameLen]byte
+	start := 0
+	for i := 0; i <= len(reqHeaders); i++ {
+		if i < len(reqHeaders) && reqHeaders[i] != sep {
+			continue
+		}
+
+		// trim spaces of the current header name
+		end := i
+		for ; start < end && reqHeaders[start] == ' '; start++ {
+		}
+		for ; end > start && reqHeaders[end-1] == ' '; end-- {
 		}
+
+		if start < end {
+			// lower case the header name, append allocates only if the name doesn't fit into buf
+			header := buf[:0]
+			for j := start; j < end; j++ {
+				b := reqHeaders[j]
+				if 'A' <= b && b <= 'Z' {
+					b ^= 0x20
+				}
+				header = append(header, b)
+			}
+
+			// check if header are allowed
+			// The compiler recognizes m[string(byteSlice)] as a special case, no conversion happens
+			if !allowed[string(header)] {
+				return false
+			}
+		}
+
+		start = i + 1
 	}
 
 	return true
 }
 
-// Filter cors filter middleware
+// Filter cors filter middleware.
+// The filter owns the single valued CORS response headers (Access-Control-Allow-Origin, Access-Control-Allow-Methods, ...):
+// they are always written with Set, replacing any value already there
 func Filter(config Config) (fn func(next http.Handler) http.Handler) {
 	c := initialize(config)
 
-	fn = func(next http.Handler) http.Handler {
+	return c.handler
+}
 
-		// TODO: scorporare questa funzione per rendere più semplice l'integrazione con GIn e framework che usano HandlerFunc per i middleware
-		filter := func(w http.ResponseWriter, r *http.Request) {
+// Cors a compiled cors filter, use it instead of Filter when you need to inspect the filter at runtime
+type Cors struct {
+	c *cors
+}
 
-			origin := r.Header.Get(OriginHeader)
+// New compile the config in a cors filter
+func New(config Config) *Cors {
+	return &Cors{c: initialize(config)}
+}
 
-			// It's a same origin request ?
-			if origin == "" {
-				next.ServeHTTP(w, r)
-				return
-			}
+// Handler the cors filter middleware
+func (c *Cors) Handler(next http.Handler) http.Handler {
+	return c.c.handler(next)
+}
 
-			// Allways add "Vary:Origin" header
-			w.Header().Add(VaryHeader, OriginHeader)
+// checkExposedHeaders return the exposed headers list, trimmed and without the empty and the invalid header names (e.g. "Content Type").
+// It logs a warning for the empty exposed headers, and for the request headers that are probably meant to be allowed headers
+func (c *cors) checkExposedHeaders(exposedHeaders string) string {
+	names := make([]string, 0, strings.Count(exposedHeaders, ",")+1)
+	for _, header := range strings.Split(exposedHeaders, ",") {
+		header = strings.TrimSpace(header)
+		name := strings.ToLower(header)
+		if name == "" {
+			c.logWrap("Warning: ExposedHeaders %q contains an empty header name.", exposedHeaders)
+			continue
+		} else if !isToken(name) {
+			c.logWrap("Error: ignore the exposed header %q, it isn't a valid header name.", header)
+			continue
+		} else if requestHeaders[name] && !c.allowAllHeaders && !c.allowedHeaders[name] {
+			c.logWrap("Warning: ExposedHeaders contains %s, a request header. It should probably be in AllowedHeaders.", header)
+		}
+		names = append(names, header)
+	}
 
-			if !c.isOriginAllowed(origin) {
-				c.logWrap("Origin %+v from %s not allowed", origin, r.RemoteAddr)
-				w.WriteHeader(http.StatusForbidden)
-				// exit chain
-				return
-			}
+	return strings.Join(names, ",")
+}
 
-			// handle cors request common parts
-			if !c.isMethodAllowed(r.Method) {
-				c.logWrap("Request method %+v from %s not allowed", r.Method, r.RemoteAddr)
-				w.WriteHeader(http.StatusMethodNotAllowed)
-				// exit chain
-				return
-			}
+// Close stop the background refresh of the origins, see OriginsRefreshInterval, and the signal watch, see WatchSignal.
+// The filter still works, with the last loaded origins. It's safe to call it more than once
+func (c *Cors) Close() {
+	c.c.close()
+}
 
-			// Ok, origin and method are allowed
-			w.Header().Add(AccessControlAllowOrigin, origin)
+// String return the filter configuration
+func (c *Cors) String() string {
+	return c.c.String()
+}
 
-			// if it's a simple cross-origin request, handle them
-			if r.Method != http.MethodOptions {
+// AllowedOriginPatterns return a human readable description of the allowed origins matchers:
+// "*" if all origins are allowed, "$self", the static origins, the suffix ("*.bar.com"), any scheme ("*://foobar.com"),
+// top level domain ("https://example.*") patterns and the regular expressions sources, prefixed by "regexp:".
+// If AllowOriginFunc is set, "func" is appended, then "matcher" for each custom OriginMatcher, and "claims" if OriginClaimVerifier is set
+func (c *Cors) AllowedOriginPatterns() []string {
+	p := c.c.getOrigins().patterns()
+	if c.c.originFunc != nil {
+		p = append(p, "func")
+	}
 
-				c.logWrap("Request from %+v", r.RemoteAddr)
+	for range c.c.originMatchers {
+		p = append(p, "matcher")
+	}
 
-				if c.exposeHeader {
-					w.Header().Add(AccessControlExposeHeaders, c.exposedHeaders)
-				}
+	if c.c.claimVerifier != nil {
+		p = append(p, "claims")
+	}
 
-				if c.allowCredentials {
-					w.Header().Add(AccessControlAllowCredentials, "true")
-				}
+	return p
+}
 
-				next.ServeHTTP(w, r)
+// rejectPreflight write the rejection status code of a disallowed preflight request, forwarding the request if ForwardDisallowedPreflight is set
+func (c *cors) rejectPreflight(w http.ResponseWriter, r *http.Request, next http.Handler, code int) {
+	if c.forwardDisallowedPreflight {
+		sw := &statusWriter{ResponseWriter: w, code: code}
+		next.ServeHTTP(sw, r)
+		// the next handler may not write anything
+		sw.writeStatus()
+		return
+	}
+
+	w.WriteHeader(code)
+}
+
+// forwardPreflight return true if the allowed preflight request r must be forwarded to the next handler
+func (c *cors) forwardPreflight(r *http.Request) bool {
+	if len(c.forwardRequestPaths) == 0 {
+		return c.forwardRequest
+	}
+
+	for _, p := range c.forwardRequestPaths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchSameOrigin return true if, with UseFetchMetadata, the request r isn't a cross origin request by its Sec-Fetch-Site header
+func (c *cors) fetchSameOrigin(r *http.Request) bool {
+	if !c.useFetchMetadata {
+		return false
+	}
+	site := r.Header.Get(SecFetchSiteHeader)
+	return site == "same-origin" || site == "none"
+}
+
+// isRejectStatus return true if code is a client or server error status code
+func isRejectStatus(code int) bool {
+	return code >= 400 && code <= 599
+}
+
+// handler wrap next with the cors filter
+func (c *cors) handler(next http.Handler) http.Handler {
+	// TODO: scorporare questa funzione per rendere più semplice l'integrazione con GIn e framework che usano HandlerFunc per i middleware
+	filter := func(w http.ResponseWriter, r *http.Request) {
+
+		if len(c.crossOriginResourcePolicy) > 0 {
+			w.Header().Set(CrossOriginResourcePolicyHeader, c.crossOriginResourcePolicy)
+		}
+
+		if c.useFetchMetadata {
+			// the CORS headers depend on Sec-Fetch-Site too
+			addVary(w.Header(), OriginHeader, SecFetchSiteHeader)
+		}
+
+		origin := r.Header.Get(OriginHeader)
+		if origin == "" && c.fallbackToReferer {
+			// the response depends on the Referer, and on the request mode
+			addVary(w.Header(), RefererHeader, SecFetchModeHeader)
+			if !mayBeNavigation(r) {
+				origin = refererOrigin(r)
+			}
+		}
+
+		// It's a same origin request ?
+		if origin == "" {
+			if r.Method == http.MethodOptions && c.defaultOptionsHandler != nil {
+				c.defaultOptionsHandler.ServeHTTP(w, r)
 				return
 			}
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			// No, it's a prefligth request, handle them
+		var span Span
+		if c.tracer != nil {
+			var ctx context.Context
+			ctx, span = c.tracer.Start(r.Context(), "cors")
+			defer span.End()
+			r = r.WithContext(ctx)
+			span.SetAttribute(traceAttrOrigin, origin)
+		}
 
-			// Add others value to Vary header
-			w.Header().Add(VaryHeader, AccessControlRequestMethod+", "+AccessControlRequestHeaders)
+		if c.canonicalVary {
+			hw := &headerWriter{ResponseWriter: w, rewrite: canonicalVary}
+			// the next handler may not write anything
+			defer hw.apply()
+			w = hw
+		}
 
-			c.logWrap("Preflight request from %s", r.RemoteAddr)
+		parsed := parseOrigin(origin)
+		sameOrigin := c.skipSameOrigin && parsed.sameOrigin(parseOrigin(serverOrigin(r)))
 
-			acReqMethod := r.Header.Get(AccessControlRequestMethod)
+		// Allways add "Vary:Origin" header, but for the same origin requests if asked
+		if !sameOrigin || !c.omitSameOriginVary {
+			addVary(w.Header(), OriginHeader)
+		}
 
-			if !c.isMethodAllowed(acReqMethod) {
-				c.logWrap("Preflight request not valid, requested method %s non allowed", acReqMethod)
-				w.WriteHeader(http.StatusMethodNotAllowed)
-				// exit chain
+		// browsers send Origin even for some same origin requests, the response still varies on Origin
+		if sameOrigin {
+			traceDecision(span, "same_origin")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := c.decide(r, origin, parsed)
+		if span != nil {
+			span.SetAttribute(traceAttrMatcher, d.match.String())
+		}
+		if d.reported {
+			c.logRequest(r, LogWarn, "Origin %+v from %s not allowed, served in report only mode", origin, remoteAddr(r))
+			c.report(r, origin, "origin_not_allowed")
+		}
+		if d.fetchSameOrigin && d.status == 0 {
+			// all checks passed, but it isn't a CORS request: no CORS header
+			traceDecision(span, "same_origin")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if d.status != 0 && (!d.preflightRejected || d.fetchSameOrigin) {
+			traceDecision(span, d.reason)
+			c.logRequest(r, LogWarn, "%s", d.message)
+			if !d.originAllowed && c.silentReject {
+				next.ServeHTTP(w, r)
 				return
 			}
+			if d.status == http.StatusMethodNotAllowed {
+				// a 405 response must list the valid methods
+				w.Header().Set(AllowHeader, d.allowedMethods)
+			}
+			w.WriteHeader(d.status)
+			// exit chain
+			return
+		}
 
-			acReqHeaders := r.Header.Get(AccessControlRequestHeaders)
+		// Ok, origin and method are allowed
+		reflected, ok := c.originAliases[origin]
+		if !ok {
+			reflected = canonicalOrigin(origin)
+			if c.lowerCaseReflectedHost && d.match == matchPattern {
+				reflected = parsed.lowerCaseHost()
+			}
+			if c.normalizeReflectedOrigin {
+				reflected = dropDefaultPort(reflected)
+			}
+		}
+		if len(c.originTemplate) > 0 {
+			reflected = strings.Replace(c.originTemplate, OriginTemplateOrigin, reflected, -1)
+		}
+		w.Header().Set(AccessControlAllowOrigin, reflected)
 
-			if !c.areReqHeadersAllowed(acReqHeaders) {
-				c.logWrap("Preflight request not valid, request headers not allowed")
-				w.WriteHeader(http.StatusForbidden)
-				// exit chain
-				return
+		if warning := c.originWarning(parsed); warning != "" {
+			c.logRequest(r, LogInfo, "Deprecated origin %+v from %s", origin, remoteAddr(r))
+			if !hasValue(w.Header()[WarningHeader], warning) {
+				w.Header().Add(WarningHeader, warning)
 			}
+		}
+
+		if c.suppressHeadersOnServerError {
+			w = &serverErrorWriter{ResponseWriter: w}
+		}
 
-			w.Header().Add(AccessControlAllowMethods, c.allowedMethodsString)
+		if c.privateCacheOnReflect && c.reflectionVaries() {
+			hw := &headerWriter{ResponseWriter: w, rewrite: privateCacheControl}
+			// the next handler may not write anything
+			defer hw.apply()
+			w = hw
+		}
 
-			if c.allowAllHeaders {
-				// return the list of requested headers
-				w.Header().Add(AccessControlAllowHeaders, acReqHeaders)
+		// if it's a simple cross-origin request, handle them
+		if !d.preflight {
 
-			} else {
-				w.Header().Add(AccessControlAllowHeaders, c.allowedHeadersString)
+			traceDecision(span, d.reason)
+			c.logRequest(r, LogDebug, "Request from %+v", remoteAddr(r))
+
+			if c.exposeHeader {
+				w.Header().Set(AccessControlExposeHeaders, c.exposedHeaders)
 			}
 
-			if c.allowCredentials {
-				w.Header().Add(AccessControlAllowCredentials, "true")
+			if c.credentialsAllowed(d.match, r) {
+				w.Header().Set(AccessControlAllowCredentials, "true")
 			}
 
-			if c.maxAge != "0" {
-				w.Header().Add(AccessControlControlMaxAge, c.maxAge)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// No, it's a prefligth request, handle them.
+		// Access-Control-Expose-Headers is meaningless here, it's emitted only on the actual responses
+
+		// Add others value to Vary header
+		if c.allowPrivateNetwork {
+			addVary(w.Header(), AccessControlRequestMethod, AccessControlRequestHeaders, AccessControlRequestPrivateNetwork)
+		} else {
+			addVary(w.Header(), AccessControlRequestMethod, AccessControlRequestHeaders)
+		}
+
+		c.logRequest(r, LogInfo, "Preflight request from %s", remoteAddr(r))
+
+		// a bare OPTIONS request, without Access-Control-Request-Method, isn't a real preflight
+		if d.bareOptions {
+			traceDecision(span, d.reason)
+			if c.credentialsAllowed(d.match, r) {
+				w.Header().Set(AccessControlAllowCredentials, "true")
 			}
 
-			// forward request if required
-			if c.forwardRequest {
+			if c.forwardPreflight(r) {
 				next.ServeHTTP(w, r)
 				return
 			}
 			// exit chain with status HTTP 200
 			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if d.status != 0 {
+			traceDecision(span, d.reason)
+			c.logRequest(r, LogWarn, "%s", d.message)
+			c.rejectPreflight(w, r, next, d.status)
+			// exit chain
+			return
 		}
 
-		return http.HandlerFunc(filter)
+		if c.metrics != nil && c.preflights.track(origin, d.requestMethod) {
+			c.metrics.RepeatedPreflight(origin, d.requestMethod)
+		}
+
+		traceDecision(span, d.reason)
+		allowedMethods := d.allowedMethods
+		switch c.methodAdvertisement {
+		case AdvertiseReflectRequest:
+			allowedMethods = d.requestMethod
+		case AdvertiseFullPlusOptions:
+			allowedMethods = withOptions(allowedMethods)
+		}
+		w.Header().Set(AccessControlAllowMethods, allowedMethods)
+
+		if c.allowHeadersOnlyIfRequested && len(strings.TrimSpace(d.requestHeaders)) == 0 {
+			// no headers requested, nothing to allow
+		} else if d.reflectHeaders {
+			// return the list of requested headers, in the same order and case
+			w.Header().Set(AccessControlAllowHeaders, trimHeaders(d.requestHeaders))
+
+		} else if len(d.allowedHeaders) > 0 {
+			w.Header().Set(AccessControlAllowHeaders, d.allowedHeaders)
+		}
+
+		if c.credentialsAllowed(d.match, r) {
+			w.Header().Set(AccessControlAllowCredentials, "true")
+		}
+
+		if c.allowPrivateNetwork && r.Header.Get(AccessControlRequestPrivateNetwork) == "true" &&
+			(c.privateNetworkOrigins == nil || c.privateNetworkOrigins.isAllowed(parsed, c.allowedSchemes)) {
+			w.Header().Set(AccessControlAllowPrivateNetwork, "true")
+		}
+
+		if maxAge := c.originMaxAge(parsed); maxAge != "0" {
+			w.Header().Set(AccessControlControlMaxAge, maxAge)
+		}
+
+		// forward request if required, the CORS headers are already set so the handler can write its own status
+		if c.forwardPreflight(r) {
+			if c.stripOriginOnForward {
+				// never change the caller request
+				r = r.Clone(r.Context())
+				r.Header.Del(OriginHeader)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// exit chain with status HTTP 200
+		w.WriteHeader(http.StatusOK)
 	}
 
-	return fn
+	return http.HandlerFunc(filter)
 }
@@ -1,12 +1,18 @@
 package cors
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Tests inspired by Jetty CORS filter and github.com/rs/cors
@@ -32,6 +38,14 @@ func assertHeaders(t *testing.T, resHeaders http.Header, reqHeaders map[string]s
 	}
 }
 
+func assertNoHeaders(t *testing.T, resHeaders http.Header, names ...string) {
+	for _, name := range names {
+		if actual, ok := resHeaders[name]; ok {
+			t.Errorf("Unexpected header `%s', got `%s'", name, strings.Join(actual, ", "))
+		}
+	}
+}
+
 func assertResponse(t *testing.T, res *httptest.ResponseRecorder, responseCode int) {
 	if responseCode != res.Code {
 		t.Errorf("expected response code to be %d but got %d. ", responseCode, res.Code)
@@ -594,6 +608,8 @@ func TestToLower(t *testing.T) {
 		{"fOOBAr", "foobar"},
 		{" FoO BaR ", " foo bar "},
 		{"FoO@@Bar", "foo@@bar"},
+		{"BÜCHER-Ä", "bÜcher-Ä"},
+		{"X-日本語-Header", "x-日本語-header"},
 	}
 
 	for _, tt := range tests {
@@ -606,6 +622,38 @@ func TestToLower(t *testing.T) {
 	}
 }
 
+// the request headers are lower cased on copies, never in place: the header values seen by the next handlers are unchanged
+func TestRequestHeadersUnchanged(t *testing.T) {
+	const reqHeaders = "X-Header-1, Content-TYPE,X-HEADER-2"
+
+	for _, allowedHeaders := range []string{"X-Header-1,Content-Type,X-Header-2", "*"} {
+		t.Run(allowedHeaders, func(t *testing.T) {
+			c := initialize(Config{AllowedOrigins: "http://foobar.com", AllowedHeaders: allowedHeaders})
+
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", reqHeaders)
+
+			res := httptest.NewRecorder()
+			Filter(Config{AllowedOrigins: "http://foobar.com", AllowedHeaders: allowedHeaders})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if v := req.Header.Get("Access-Control-Request-Headers"); v != reqHeaders {
+				t.Errorf("the filter changed the request headers, got %q, want %q", v, reqHeaders)
+			}
+
+			v := req.Header.Get("Access-Control-Request-Headers")
+			if !c.areReqHeadersAllowed(v) || !headersAllowed(allowed(normalizeHeaders(v)), v) {
+				t.Errorf("the request headers %q aren't allowed", v)
+			}
+			if v := req.Header.Get("Access-Control-Request-Headers"); v != reqHeaders {
+				t.Errorf("normalizeHeaders or headersAllowed changed the header value, got %q, want %q", v, reqHeaders)
+			}
+		})
+	}
+}
+
 func TestNormalizeHeader(t *testing.T) {
 	var tests = []struct {
 		in  string
@@ -690,3 +738,3769 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestAreReqHeadersAllowed(t *testing.T) {
+	c := initialize(Config{
+		AllowedHeaders: "X-Header-1,X-Header-2",
+	})
+
+	var tests = []struct {
+		in  string
+		out bool
+	}{
+		{"", true},
+		{"X-Header-1", true},
+		{"x-header-2", true},
+		{" X-HEADER-2 , x-Header-1 ", true},
+		{",,X-Header-1,,", true},
+		{" , ", true},
+		{"X-Header-3", false},
+		{"X-Header-1, X-Header-3", false},
+		{"X-Header-1 X-Header-2", false},
+		{"X-Header-1" + strings.Repeat("-", maxHeaderNameLen), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if ok := c.areReqHeadersAllowed(tt.in); ok != tt.out {
+				t.Errorf("got %v, want %v", ok, tt.out)
+			}
+		})
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.areReqHeadersAllowed("X-Header-1, x-header-2")
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations, want 0", allocs)
+	}
+}
+
+func TestTLDWildcardOrigin(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "https://example.*",
+	})
+
+	var tests = []struct {
+		origin string
+		code   int
+	}{
+		{"https://example.de", http.StatusOK},
+		{"https://example.com", http.StatusOK},
+		{"https://example.fr:8443", http.StatusOK},
+		{"https://example.com.evil.net", http.StatusForbidden},
+		{"https://example.", http.StatusForbidden},
+		{"https://example.com:", http.StatusForbidden},
+		{"https://example.com/foo", http.StatusForbidden},
+		{"http://example.de", http.StatusForbidden},
+		{"https://fooexample.de", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if tt.code == http.StatusOK {
+				assertHeaders(t, res.Header(), map[string]string{
+					"Access-Control-Allow-Origin": tt.origin,
+				})
+			}
+		})
+	}
+}
+
+func TestMethodsProvider(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		AllowedMethods: "GET,POST,DELETE,OPTIONS",
+		MethodsProvider: func(r *http.Request) []string {
+			if r.URL.Path == "/items" {
+				return []string{"GET", "POST", "DELETE"}
+			}
+			return []string{"GET"}
+		},
+	})
+
+	var tests = []struct {
+		path    string
+		method  string
+		code    int
+		methods string
+	}{
+		{"/items", "DELETE", http.StatusOK, "GET,POST,DELETE"},
+		{"/items", "GET", http.StatusOK, "GET,POST,DELETE"},
+		{"/items", "PUT", http.StatusMethodNotAllowed, ""},
+		{"/foo", "GET", http.StatusOK, "GET"},
+		{"/foo", "DELETE", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.method, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com"+tt.path, nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.method)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != tt.methods {
+				t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `%s', got `%s'", tt.methods, actual)
+			}
+		})
+	}
+}
+
+// the preflight allows only the methods that the actual request is allowed to use
+func TestMethodsProviderActualRequest(t *testing.T) {
+	var tests = []struct {
+		name    string
+		methods string
+		code    int
+	}{
+		{"provider method not allowed", "", http.StatusMethodNotAllowed},
+		{"provider method allowed", "GET,PUT,OPTIONS", http.StatusOK},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				AllowedMethods: tt.methods,
+				MethodsProvider: func(r *http.Request) []string {
+					return []string{"PUT"}
+				},
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/items", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "PUT")
+
+			f(ok).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+
+			res = httptest.NewRecorder()
+			req, _ = http.NewRequest("PUT", "http://example.com/items", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			f(ok).ServeHTTP(res, req)
+
+			// the actual request gets the same outcome of its preflight
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestSilentReject(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		SilentReject:   true,
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://barbaz.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertNoHeaders(t, res.Header(),
+		"Access-Control-Allow-Origin",
+		"Access-Control-Allow-Credentials",
+		"Access-Control-Expose-Headers",
+	)
+
+	// forwarded to the application as a same origin request
+	assertResponse(t, res, http.StatusOK)
+	if body := res.Body.String(); body != "test" {
+		t.Errorf("expected body `test', got `%s'", body)
+	}
+}
+
+// countMetrics count the repeated preflight requests
+type countMetrics struct {
+	repeated int
+}
+
+func (m *countMetrics) RepeatedPreflight(origin, method string) {
+	m.repeated++
+}
+
+func TestRepeatedPreflightMetrics(t *testing.T) {
+	metrics := &countMetrics{}
+	config := Config{
+		AllowedOrigins: "http://foobar.com,http://barbaz.com",
+		AllowedMethods: "GET,PUT,OPTIONS",
+		MaxAge:         10,
+		Metrics:        metrics,
+	}
+
+	// drive the MaxAge window with the injected clock
+	now := time.Now()
+	config.setNow(func() time.Time { return now })
+
+	h := Filter(config)(testHandler)
+
+	preflight := func(origin, method string) {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+		req.Header.Add("Origin", origin)
+		req.Header.Add("Access-Control-Request-Method", method)
+		h.ServeHTTP(res, req)
+		assertResponse(t, res, http.StatusOK)
+	}
+
+	var tests = []struct {
+		name     string
+		origin   string
+		method   string
+		elapsed  time.Duration
+		repeated int
+	}{
+		{"first preflight", "http://foobar.com", "GET", 0, 0},
+		{"repeated within window", "http://foobar.com", "GET", 5 * time.Second, 1},
+		{"other method", "http://foobar.com", "PUT", 0, 1},
+		{"other origin", "http://barbaz.com", "GET", 0, 1},
+		{"repeated again within window", "http://foobar.com", "GET", 9 * time.Second, 2},
+		{"after the window", "http://foobar.com", "GET", 10 * time.Second, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+			preflight(tt.origin, tt.method)
+			if metrics.repeated != tt.repeated {
+				t.Errorf("got %d repeated preflights, want %d", metrics.repeated, tt.repeated)
+			}
+		})
+	}
+}
+
+func TestAnySchemeOrigin(t *testing.T) {
+	var tests = []struct {
+		name    string
+		schemes string
+		origin  string
+		code    int
+	}{
+		{"http subdomain", "", "http://a.example.com", http.StatusOK},
+		{"https subdomain", "", "https://b.example.com", http.StatusOK},
+		{"ftp subdomain", "", "ftp://a.example.com", http.StatusOK},
+		{"nested subdomain", "", "https://a.b.example.com", http.StatusOK},
+		{"static host", "", "https://foobar.com", http.StatusOK},
+		{"apex", "", "https://example.com", http.StatusForbidden},
+		{"other domain", "", "https://badexample.com", http.StatusForbidden},
+		{"no scheme", "", "a.example.com", http.StatusForbidden},
+		{"restricted http", "http,https", "http://a.example.com", http.StatusOK},
+		{"restricted https", "http,https", "HTTPS://b.example.com", http.StatusOK},
+		{"restricted ftp", "http,https", "ftp://a.example.com", http.StatusForbidden},
+		{"restricted static host", "http,https", "ftp://foobar.com", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins: "*://*.example.com, *://foobar.com",
+				AllowedSchemes: tt.schemes,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestMaxAgeWithoutOptionsWarning(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := log.New(buf, "", log.LstdFlags)
+
+	var tests = []struct {
+		name    string
+		methods string
+		maxAge  int
+		warning bool
+	}{
+		{"options not allowed", "GET,POST", 10, true},
+		{"options allowed", "GET,POST,OPTIONS", 10, false},
+		{"default methods", "", 10, false},
+		{"max age not set", "GET,POST", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			initialize(Config{
+				AllowedMethods: tt.methods,
+				MaxAge:         tt.maxAge,
+				Logger:         logger,
+			})
+			if warning := strings.Contains(buf.String(), "is useless"); warning != tt.warning {
+				t.Errorf("got warning %v, want %v, log %q", warning, tt.warning, buf.String())
+			}
+		})
+	}
+}
+
+func TestOriginsLoader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	loaded := []string{"http://foobar.com", "*.bar.com"}
+	var loadErr error
+
+	c := initialize(Config{
+		AllowedOrigins: "http://barbaz.com",
+		OriginsLoader: func() ([]string, error) {
+			return loaded, loadErr
+		},
+		Logger: log.New(buf, "", log.LstdFlags),
+	})
+
+	var tests = []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://foobar.com", true},
+		{"http://foo.bar.com", true},
+		{"http://barbaz.com", false},
+	}
+
+	check := func(t *testing.T) {
+		for _, tt := range tests {
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("origin %s: got %v, want %v", tt.origin, allowed, tt.allowed)
+			}
+		}
+	}
+
+	t.Run("initial load", check)
+
+	// a failed refresh keeps the last good origins
+	loaded, loadErr = nil, errors.New("config service unavailable")
+	c.loadOrigins()
+	t.Run("failed refresh", check)
+	if !strings.Contains(buf.String(), "config service unavailable") {
+		t.Errorf("expected the loader error to be logged, got %q", buf.String())
+	}
+
+	loaded, loadErr = []string{"http://barbaz.com"}, nil
+	c.loadOrigins()
+	tests = []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://foobar.com", false},
+		{"http://foo.bar.com", false},
+		{"http://barbaz.com", true},
+	}
+	t.Run("refresh", check)
+}
+
+func TestOriginsLoaderInitialError(t *testing.T) {
+	c := initialize(Config{
+		AllowedOrigins: "http://barbaz.com",
+		OriginsLoader: func() ([]string, error) {
+			return nil, errors.New("config service unavailable")
+		},
+	})
+
+	// fallback to AllowedOrigins
+	if !c.isOriginAllowed("http://barbaz.com", nil) {
+		t.Errorf("expected AllowedOrigins to be used when the loader fails")
+	}
+}
+
+func TestOriginsLoaderInitialErrorWithoutAllowedOrigins(t *testing.T) {
+	fail := true
+	c := New(Config{
+		AllowCredentials: true,
+		OriginsLoader: func() ([]string, error) {
+			if fail {
+				return nil, errors.New("config service unavailable")
+			}
+			return []string{"https://good.com"}, nil
+		},
+	})
+
+	// no origin is allowed until the loader succeeds
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "https://evil.com")
+
+	c.Handler(testHandler).ServeHTTP(res, req)
+
+	assertResponse(t, res, http.StatusForbidden)
+	assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+
+	fail = false
+	c.Reload()
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "https://good.com")
+
+	c.Handler(testHandler).ServeHTTP(res, req)
+
+	assertResponse(t, res, http.StatusOK)
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin":      "https://good.com",
+		"Access-Control-Allow-Credentials": "true",
+	})
+}
+
+func TestCanonicalOrigin(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"http://foo.com", "http://foo.com"},
+		{"http://foo.com/", "http://foo.com"},
+		{"http://foo.com:8080/", "http://foo.com:8080"},
+		{"https://foo.com/bar?baz#qux", "https://foo.com"},
+		{"https://foo.com?bar", "https://foo.com"},
+		{"null", "null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if s := canonicalOrigin(tt.in); s != tt.out {
+				t.Errorf("got %q, want %q", s, tt.out)
+			}
+		})
+	}
+}
+
+func TestSlashedOrigin(t *testing.T) {
+	f := Filter(Config{})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foo.com/")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != "http://foo.com" {
+		t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `http://foo.com', got `%s'", actual)
+	}
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestExposeSafelistedHeaders(t *testing.T) {
+	var tests = []struct {
+		name     string
+		exposed  string
+		expected string
+	}{
+		{"only safelisted", "", "Cache-Control,Content-Language,Content-Length,Content-Type,Expires,Last-Modified,Pragma"},
+		{"with exposed headers", "X-Header-1", "X-Header-1,Cache-Control,Content-Language,Content-Length,Content-Type,Expires,Last-Modified,Pragma"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:          "http://foobar.com",
+				ExposedHeaders:          tt.exposed,
+				ExposeSafelistedHeaders: true,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Expose-Headers"); actual != tt.expected {
+				t.Errorf("Invalid header `Access-Control-Expose-Headers', wanted `%s', got `%s'", tt.expected, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestAllowHeadersOnlyIfRequested(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins:              "http://foobar.com",
+		AllowedHeaders:              "X-Header-1,X-Header-2",
+		AllowHeadersOnlyIfRequested: true,
+	})
+
+	// no request headers
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "GET")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertNoHeaders(t, res.Header(), "Access-Control-Allow-Headers")
+	assertResponse(t, res, http.StatusOK)
+
+	// with request headers
+	res = httptest.NewRecorder()
+	req.Header.Add("Access-Control-Request-Headers", "X-Header-1")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Headers": "X-Header-1,X-Header-2",
+	})
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestRejectSimpleMethodPreflight(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins:              "http://foobar.com",
+		AllowedMethods:              "GET,POST,PUT,OPTIONS",
+		AllowedHeaders:              "Accept,Content-Type,X-Header-1",
+		RejectSimpleMethodPreflight: true,
+	})
+
+	var tests = []struct {
+		name    string
+		method  string
+		headers string
+		code    int
+	}{
+		{"put", "PUT", "", http.StatusOK},
+		{"spurious get", "GET", "", http.StatusBadRequest},
+		{"spurious post with simple header", "POST", "Accept", http.StatusBadRequest},
+		{"get with custom header", "GET", "X-Header-1", http.StatusOK},
+		{"post with content type", "POST", "Content-Type", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Add("Access-Control-Request-Headers", tt.headers)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestLogEmptyRemoteAddr(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		Logger:         log.New(buf, "", 0),
+	})
+
+	var tests = []struct {
+		remoteAddr string
+		out        string
+	}{
+		{"", "Origin http://barbaz.com from unknown not allowed"},
+		{"192.168.1.1:1234", "Origin http://barbaz.com from 192.168.1.1:1234 not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			buf.Reset()
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Add("Origin", "http://barbaz.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if s := buf.String(); !strings.Contains(s, tt.out) {
+				t.Errorf("got %q, want %q", s, tt.out)
+			}
+		})
+	}
+}
+
+func TestForwardRequestHandlerStatus(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		ForwardRequest: true,
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "GET")
+
+	f(handler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+		"Access-Control-Allow-Origin":  "http://foobar.com",
+		"Access-Control-Allow-Methods": "GET",
+		"Access-Control-Allow-Headers": "Origin",
+		"Access-Control-Max-Age":       "1800",
+	})
+
+	// the handler status is kept
+	assertResponse(t, res, http.StatusAccepted)
+}
+
+func TestMaxAgeByOrigin(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com,*.partner.com,http://barbaz.com",
+		MaxAge:         10,
+		MaxAgeByOrigin: map[string]int{
+			"http://foobar.com": 7200,
+			"*.partner.com":     60,
+		},
+	})
+
+	var tests = []struct {
+		origin string
+		maxAge string
+	}{
+		{"http://foobar.com", "7200"},
+		{"http://api.partner.com", "60"},
+		{"http://barbaz.com", "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Max-Age"); actual != tt.maxAge {
+				t.Errorf("Invalid header `Access-Control-Max-Age', wanted `%s', got `%s'", tt.maxAge, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestSelfOrigin(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "$self,http://foobar.com",
+	})
+
+	var tests = []struct {
+		name   string
+		url    string
+		tls    bool
+		origin string
+		code   int
+	}{
+		{"same origin", "http://api.example.com/foo", false, "http://api.example.com", http.StatusOK},
+		{"same origin with port", "http://localhost:8080/foo", false, "http://localhost:8080", http.StatusOK},
+		{"same origin over tls", "https://api.example.com/foo", true, "https://api.example.com", http.StatusOK},
+		{"static origin", "http://api.example.com/foo", false, "http://foobar.com", http.StatusOK},
+		{"other scheme", "http://api.example.com/foo", false, "https://api.example.com", http.StatusForbidden},
+		{"other port", "http://localhost:8080/foo", false, "http://localhost:8081", http.StatusForbidden},
+		{"other host", "http://api.example.com/foo", false, "http://www.example.com", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if !tt.tls {
+				req.TLS = nil
+			}
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestLogCorrelationID(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := Filter(Config{
+		AllowedOrigins:    "http://foobar.com",
+		CorrelationHeader: "X-Request-ID",
+		Logger:            log.New(buf, "", 0),
+	})
+
+	var tests = []struct {
+		name string
+		id   string
+		out  string
+	}{
+		{"with correlation id", "42", "[cors] [42] Origin http://barbaz.com from"},
+		{"without correlation id", "", "[cors] Origin http://barbaz.com from"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://barbaz.com")
+			if tt.id != "" {
+				req.Header.Add("X-Request-ID", tt.id)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if s := buf.String(); !strings.Contains(s, tt.out) {
+				t.Errorf("got %q, want %q", s, tt.out)
+			}
+		})
+	}
+}
+
+func TestStrictRequestHeaders(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins:       "http://foobar.com",
+		AllowedHeaders:       "*",
+		StrictRequestHeaders: true,
+	})
+
+	var tests = []struct {
+		headers string
+		code    int
+	}{
+		{"X-Header-1, Content-Type", http.StatusOK},
+		{"Host", http.StatusBadRequest},
+		{"X-Header-1, Connection", http.StatusBadRequest},
+		{"content-length", http.StatusBadRequest},
+		{"Proxy-Authorization", http.StatusBadRequest},
+		{"Sec-Fetch-Mode", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.headers, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", tt.headers)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestPrivateCacheOnReflect(t *testing.T) {
+	var tests = []struct {
+		name         string
+		origins      string
+		private      bool
+		cacheControl string
+	}{
+		{"all origins", "*", true, "private"},
+		{"all origins without option", "*", false, ""},
+		{"static origin", "http://foobar.com", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:        tt.origins,
+				PrivateCacheOnReflect: tt.private,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertHeaders(t, res.Header(), map[string]string{
+				"Vary":                        "Origin",
+				"Access-Control-Allow-Origin": "http://foobar.com",
+			})
+			if actual := res.Header().Get("Cache-Control"); actual != tt.cacheControl {
+				t.Errorf("Invalid header `Cache-Control', wanted `%s', got `%s'", tt.cacheControl, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestNoAllowedHeaders(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		AllowedHeaders: NoAllowedHeaders,
+	})
+
+	var tests = []struct {
+		name    string
+		headers string
+		code    int
+	}{
+		{"no headers", "", http.StatusOK},
+		{"custom header", "X-Custom", http.StatusForbidden},
+		{"default header", "Last-Event-ID", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			if tt.headers != "" {
+				req.Header.Add("Access-Control-Request-Headers", tt.headers)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertNoHeaders(t, res.Header(), "Access-Control-Allow-Headers")
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	var tests = []struct {
+		name           string
+		enabled        bool
+		request        string
+		vary           string
+		allowPrivate   string
+		noAllowPrivate bool
+	}{
+		{"enabled", true, "true", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers, Access-Control-Request-Private-Network", "true", false},
+		{"enabled without request", true, "", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers, Access-Control-Request-Private-Network", "", true},
+		{"disabled", false, "true", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:      "http://foobar.com",
+				AllowPrivateNetwork: tt.enabled,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			if tt.request != "" {
+				req.Header.Add("Access-Control-Request-Private-Network", tt.request)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := strings.Join(res.Header()["Vary"], ", "); actual != tt.vary {
+				t.Errorf("Invalid header `Vary', wanted `%s', got `%s'", tt.vary, actual)
+			}
+			assertHeaders(t, res.Header(), map[string]string{
+				"Access-Control-Allow-Private-Network": tt.allowPrivate,
+			})
+			if tt.noAllowPrivate {
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Private-Network")
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestTrimHeaders(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"X-Header-1", "X-Header-1"},
+		{"X-Header-1,X-Header-2", "X-Header-1, X-Header-2"},
+		{"  X-Header-2 ,\tx-HEADER-1  ,, ", "X-Header-2, x-HEADER-1"},
+		{" , ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if s := trimHeaders(tt.in); s != tt.out {
+				t.Errorf("got %q, want %q", s, tt.out)
+			}
+		})
+	}
+}
+
+func TestAllowedWildcardHeaderMessyRequest(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		AllowedHeaders: "*",
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "GET")
+	req.Header.Add("Access-Control-Request-Headers", "  X-Header-2 ,X-HEADER-1,,x-header-3  ")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != "X-Header-2, X-HEADER-1, x-header-3" {
+		t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `X-Header-2, X-HEADER-1, x-header-3', got `%s'", actual)
+	}
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestCredentialsOnlyForExactOrigins(t *testing.T) {
+	var tests = []struct {
+		name        string
+		method      string
+		origin      string
+		credentials string
+	}{
+		{"exact origin", "GET", "http://foobar.com", "true"},
+		{"suffix origin", "GET", "http://api.bar.com", ""},
+		{"exact origin preflight", "OPTIONS", "http://foobar.com", "true"},
+		{"suffix origin preflight", "OPTIONS", "http://api.bar.com", ""},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:                 "http://foobar.com, *.bar.com",
+		AllowCredentials:               true,
+		CredentialsOnlyForExactOrigins: true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			if tt.method == "OPTIONS" {
+				req.Header.Add("Access-Control-Request-Method", "GET")
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertHeaders(t, res.Header(), map[string]string{
+				"Access-Control-Allow-Origin": tt.origin,
+			})
+			if actual := res.Header().Get("Access-Control-Allow-Credentials"); actual != tt.credentials {
+				t.Errorf("Invalid header `Access-Control-Allow-Credentials', wanted `%s', got `%s'", tt.credentials, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestParseOrigin(t *testing.T) {
+	var tests = []struct {
+		origin   string
+		scheme   string
+		host     string
+		port     string
+		hostPort string
+		effPort  string
+	}{
+		{"http://foobar.com", "http", "foobar.com", "", "foobar.com", "80"},
+		{"https://foobar.com", "https", "foobar.com", "", "foobar.com", "443"},
+		{"HTTP://foobar.com:8080", "http", "foobar.com", "8080", "foobar.com:8080", "8080"},
+		{"http://[::1]:8080", "http", "::1", "8080", "[::1]:8080", "8080"},
+		{"http://[::1]", "http", "::1", "", "[::1]", "80"},
+		{"http://foobar.com:8080/path?q=1", "http", "foobar.com", "8080", "foobar.com:8080", "8080"},
+		{"app://foobar", "app", "foobar", "", "foobar", ""},
+		{"null", "", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			p := parseOrigin(tt.origin)
+			if p.raw != tt.origin || p.scheme != tt.scheme || p.host != tt.host || p.port != tt.port {
+				t.Errorf("got %+v, want scheme %q, host %q, port %q", p, tt.scheme, tt.host, tt.port)
+			}
+			if s := p.hostPort(); s != tt.hostPort {
+				t.Errorf("hostPort got %q, want %q", s, tt.hostPort)
+			}
+			if s := p.effectivePort(); s != tt.effPort {
+				t.Errorf("effectivePort got %q, want %q", s, tt.effPort)
+			}
+		})
+	}
+}
+
+func TestSelfOriginDefaultPort(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: OriginSelf,
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://example.com:80")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin": "http://example.com:80",
+	})
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestAllowCredentialsFunc(t *testing.T) {
+	var tests = []struct {
+		name        string
+		method      string
+		path        string
+		credentials string
+	}{
+		{"secure", "GET", "/secure", "true"},
+		{"public", "GET", "/public", ""},
+		{"secure preflight", "OPTIONS", "/secure", "true"},
+		{"public preflight", "OPTIONS", "/public", ""},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:   "http://foobar.com",
+		AllowCredentials: false,
+		AllowCredentialsFunc: func(r *http.Request) bool {
+			return strings.HasPrefix(r.URL.Path, "/secure")
+		},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			if tt.method == "OPTIONS" {
+				req.Header.Add("Access-Control-Request-Method", "GET")
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Credentials"); actual != tt.credentials {
+				t.Errorf("Invalid header `Access-Control-Allow-Credentials', wanted `%s', got `%s'", tt.credentials, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestAllowCredentialsFuncAllOrigins(t *testing.T) {
+	f := Filter(Config{
+		AllowCredentialsFunc: func(r *http.Request) bool { return true },
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertNoHeaders(t, res.Header(), "Access-Control-Allow-Credentials")
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestMaxRequestMethodLen(t *testing.T) {
+	var tests = []struct {
+		name   string
+		maxLen int
+		method string
+		code   int
+	}{
+		{"default at limit", 0, strings.Repeat("A", 64), http.StatusOK},
+		{"default above limit", 0, strings.Repeat("A", 65), http.StatusBadRequest},
+		{"custom at limit", 8, "ABCDEFGH", http.StatusOK},
+		{"custom above limit", 8, "ABCDEFGHI", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:      "http://foobar.com",
+				AllowedMethods:      "OPTIONS,ABCDEFGH," + strings.Repeat("A", 64),
+				MaxRequestMethodLen: tt.maxLen,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.method)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestAllowedOriginPatterns(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   Config
+		patterns string
+	}{
+		{"all", Config{}, "*"},
+		{"static", Config{AllowedOrigins: "http://foobar.com,http://bar.com"}, "http://foobar.com,http://bar.com"},
+		{"self", Config{AllowedOrigins: "$self"}, "$self"},
+		{"suffix", Config{AllowedOrigins: "*.bar.com"}, "*.bar.com"},
+		{"any scheme", Config{AllowedOrigins: "*://foobar.com,*://*.bar.com"}, "*://foobar.com,*://*.bar.com"},
+		{"top level domain", Config{AllowedOrigins: "https://example.*"}, "https://example.*"},
+		{"regexp", Config{AllowedOrigins: "http://foo.*.com"}, `regexp:^http://foo\.[^/?#@]*\.com$`},
+		{"func", Config{AllowedOrigins: "http://foobar.com", AllowOriginFunc: func(string) bool { return false }}, "http://foobar.com,func"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(tt.config)
+			if p := strings.Join(c.AllowedOriginPatterns(), ","); p != tt.patterns {
+				t.Errorf("got %q, want %q", p, tt.patterns)
+			}
+			if s := c.String(); !strings.Contains(s, "AllowedOrigins: "+strings.TrimSuffix(tt.patterns, ",func")+";") {
+				t.Errorf("String() %q doesn't contain the patterns %q", s, tt.patterns)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	c := New(Config{AllowedOrigins: "http://foobar.com"})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+
+	c.Handler(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin": "http://foobar.com",
+	})
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestFallbackToReferer(t *testing.T) {
+	var tests = []struct {
+		name      string
+		fallback  bool
+		method    string
+		fetchMode string
+		referer   string
+		origin    string
+		code      int
+	}{
+		{"allowed referer", true, "GET", "cors", "http://foobar.com/page?q=1", "http://foobar.com", http.StatusOK},
+		{"disallowed referer", true, "GET", "cors", "http://bar.com/page", "", http.StatusForbidden},
+		{"disallowed referer, unsafe method", true, "POST", "", "http://bar.com/page", "", http.StatusForbidden},
+		{"navigation from a foreign site", true, "GET", "navigate", "http://bar.com/search?q=foo", "", http.StatusOK},
+		{"navigation from a foreign site, old browser", true, "GET", "", "http://bar.com/search?q=foo", "", http.StatusOK},
+		{"same origin referer", true, "GET", "cors", "http://example.com/page", "", http.StatusOK},
+		{"invalid referer", true, "GET", "cors", "page", "", http.StatusOK},
+		{"fallback disabled", false, "GET", "cors", "http://bar.com/page", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:    "http://foobar.com",
+				FallbackToReferer: tt.fallback,
+			})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Referer", tt.referer)
+			if len(tt.fetchMode) > 0 {
+				req.Header.Add("Sec-Fetch-Mode", tt.fetchMode)
+			}
+
+			f(handler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.origin {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.origin, actual)
+			}
+			if tt.fallback {
+				assertHeaders(t, res.Header(), map[string]string{"Vary": "Referer, Sec-Fetch-Mode"})
+			} else {
+				assertNoHeaders(t, res.Header(), "Vary")
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestForbidCredentials(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := Filter(Config{
+		AllowedOrigins:       "http://foobar.com",
+		AllowCredentials:     true,
+		AllowCredentialsFunc: func(r *http.Request) bool { return true },
+		ForbidCredentials:    true,
+		Logger:               log.New(buf, "", 0),
+	})
+
+	if !strings.Contains(buf.String(), "ForbidCredentials") {
+		t.Errorf("the conflicting AllowCredentials isn't logged: %q", buf.String())
+	}
+
+	for _, method := range []string{"GET", "OPTIONS"} {
+		t.Run(method, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertNoHeaders(t, res.Header(), "Access-Control-Allow-Credentials")
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestOptionsAlways200(t *testing.T) {
+	var tests = []struct {
+		name   string
+		always bool
+		code   int
+	}{
+		{"default", false, http.StatusMethodNotAllowed},
+		{"always 200", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AllowCredentials: true,
+				OptionsAlways200: tt.always,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if tt.always {
+				assertHeaders(t, res.Header(), map[string]string{
+					"Access-Control-Allow-Origin":      "http://foobar.com",
+					"Access-Control-Allow-Credentials": "true",
+				})
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Methods", "Access-Control-Max-Age")
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestSkipSameOrigin(t *testing.T) {
+	var tests = []struct {
+		name   string
+		url    string
+		origin string
+		acao   string
+		code   int
+	}{
+		{"same origin", "http://example.com/foo", "http://example.com", "", http.StatusOK},
+		{"same origin default port", "http://example.com/foo", "http://example.com:80", "", http.StatusOK},
+		{"same origin explicit port", "http://example.com:8080/foo", "http://example.com:8080", "", http.StatusOK},
+		{"same origin host case", "http://example.com/foo", "http://EXAMPLE.com", "", http.StatusOK},
+		{"other port", "http://example.com:8080/foo", "http://example.com", "", http.StatusForbidden},
+		{"other scheme", "http://example.com/foo", "https://example.com", "", http.StatusForbidden},
+		{"cross origin", "http://example.com/foo", "http://foobar.com", "http://foobar.com", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				SkipSameOrigin: true,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.acao {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.acao, actual)
+			}
+			assertHeaders(t, res.Header(), map[string]string{
+				"Vary": "Origin",
+			})
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestAdvertiseOptions(t *testing.T) {
+	var tests = []struct {
+		name      string
+		advertise bool
+		provider  []string
+		methods   string
+	}{
+		{"default", false, []string{"GET", "POST"}, "GET,POST"},
+		{"advertise", true, []string{"GET", "POST"}, "GET,POST,OPTIONS"},
+		{"already advertised", true, []string{"GET", "options"}, "GET,OPTIONS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AdvertiseOptions: tt.advertise,
+				MethodsProvider: func(r *http.Request) []string {
+					return tt.provider
+				},
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != tt.methods {
+				t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `%s', got `%s'", tt.methods, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestUnicodeOrigin(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins: "http://bücher.example,*.bücher.de",
+	})
+
+	for _, origin := range []string{"http://bücher.example", "http://shop.bücher.de"} {
+		t.Run(origin, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != origin {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", origin, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+
+	if p := parseOrigin("HTTP://BÜCHER.example:8080"); p.scheme != "http" || p.host != "BÜCHER.example" || p.port != "8080" {
+		t.Errorf("the unicode origin is corrupted by parseOrigin: %+v", p)
+	}
+}
+
+func TestNoExposeHeadersOnPreflight(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config Config
+		acrm   string
+	}{
+		{"preflight", Config{}, "GET"},
+		{"forwarded preflight", Config{ForwardRequest: true}, "GET"},
+		{"safelisted headers", Config{ExposeSafelistedHeaders: true}, "GET"},
+		{"bare options", Config{OptionsAlways200: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.AllowedOrigins = "http://foobar.com"
+			config.ExposedHeaders = "X-Header-1"
+			f := Filter(config)
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			if tt.acrm != "" {
+				req.Header.Add("Access-Control-Request-Method", tt.acrm)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertNoHeaders(t, res.Header(), "Access-Control-Expose-Headers")
+		})
+	}
+}
+
+func TestRequireCORSFetchMode(t *testing.T) {
+	var tests = []struct {
+		name      string
+		method    string
+		origin    string
+		fetchMode string
+		code      int
+	}{
+		{"post cors", "POST", "http://foobar.com", "cors", http.StatusOK},
+		{"post without fetch mode", "POST", "http://foobar.com", "", http.StatusForbidden},
+		{"put navigate", "PUT", "http://foobar.com", "navigate", http.StatusForbidden},
+		{"delete no-cors", "DELETE", "http://foobar.com", "no-cors", http.StatusForbidden},
+		{"get without fetch mode", "GET", "http://foobar.com", "", http.StatusOK},
+		{"same origin post", "POST", "http://example.com", "navigate", http.StatusOK},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("test"))
+	})
+
+	f := Filter(Config{
+		AllowedOrigins:       "http://foobar.com,$self",
+		AllowedMethods:       "GET,POST,PUT,DELETE,OPTIONS",
+		RequireCORSFetchMode: true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			if tt.fetchMode != "" {
+				req.Header.Add("Sec-Fetch-Mode", tt.fetchMode)
+			}
+
+			f(handler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+// signedOriginMatcher allow the origins with a valid signed subdomain, e.g. http://<sign>-app.foobar.com
+type signedOriginMatcher struct {
+	sign func(s string) string
+}
+
+func (m signedOriginMatcher) Match(origin string) bool {
+	const suffix = ".foobar.com"
+	if !strings.HasPrefix(origin, "http://") || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	label := origin[len("http://") : len(origin)-len(suffix)]
+	i := strings.IndexByte(label, '-')
+	return i > 0 && label[:i] == m.sign(label[i+1:])
+}
+
+func TestOriginMatchers(t *testing.T) {
+	m := signedOriginMatcher{sign: func(s string) string { return strings.ToUpper(s) + "42" }}
+
+	var tests = []struct {
+		name           string
+		allowedOrigins string
+		origin         string
+		code           int
+	}{
+		{"computed origin", "", "http://APP42-app.foobar.com", http.StatusOK},
+		{"wrong sign", "", "http://APP41-app.foobar.com", http.StatusForbidden},
+		{"only matchers", "", "http://bar.com", http.StatusForbidden},
+		{"static origin", "http://bar.com", "http://bar.com", http.StatusOK},
+		{"computed origin with static", "http://bar.com", "http://APP42-app.foobar.com", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins: tt.allowedOrigins,
+				OriginMatchers: []OriginMatcher{m},
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestLegacyAJAXCompat(t *testing.T) {
+	var tests = []struct {
+		name           string
+		compat         bool
+		allowedHeaders string
+		code           int
+		acah           string
+	}{
+		{"disabled", false, "", http.StatusForbidden, ""},
+		{"default headers", true, "", http.StatusOK, DefaultAllowedHeaders + ",X-Requested-With"},
+		{"custom headers", true, "X-Header-1", http.StatusOK, "X-Header-1,X-Requested-With"},
+		{"no headers", true, "-", http.StatusOK, "X-Requested-With"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AllowedHeaders:   tt.allowedHeaders,
+				LegacyAJAXCompat: tt.compat,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", "x-requested-with")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.acah {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.acah, actual)
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestLowerCaseReflectedHost(t *testing.T) {
+	var tests = []struct {
+		name   string
+		lower  bool
+		origin string
+		acao   string
+	}{
+		{"suffix", true, "http://FOO.bar.com", "http://foo.bar.com"},
+		{"scheme", true, "HTTP://Foo.bar.com", "HTTP://foo.bar.com"},
+		{"port", true, "http://A.qux.com:8080", "http://a.qux.com:8080"},
+		{"regexp", true, "http://foo.BAZ.com", "http://foo.baz.com"},
+		{"static", true, "http://Qux.com", "http://Qux.com"},
+		{"disabled", false, "http://FOO.bar.com", "http://FOO.bar.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:         "http://Qux.com,*.bar.com,http://foo.*.com,http://*.qux.com:*",
+				LowerCaseReflectedHost: tt.lower,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.acao {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.acao, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestMaxOrigins(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := Filter(Config{
+		AllowedOrigins: "http://a.com,http://b.com,http://c.com",
+		MaxOrigins:     2,
+		Logger:         log.New(buf, "", 0),
+	})
+
+	if !strings.Contains(buf.String(), "3 AllowedOrigins exceed MaxOrigins = 2") {
+		t.Errorf("missing error, got %q", buf.String())
+	}
+
+	// no origin is compiled, not even the first ones
+	for _, origin := range []string{"http://a.com", "http://c.com"} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Add("Origin", origin)
+
+		f(testHandler).ServeHTTP(res, req)
+
+		assertResponse(t, res, http.StatusForbidden)
+		assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+	}
+
+	list := []string{"http://a.com"}
+	c := initialize(Config{
+		OriginsLoader: func() ([]string, error) { return list, nil },
+		MaxOrigins:    2,
+	})
+
+	list = []string{"http://a.com", "http://b.com", "http://c.com"}
+	c.loadOrigins()
+
+	if c.isOriginAllowed("http://b.com", nil) {
+		t.Errorf("the loaded origins exceeding MaxOrigins aren't ignored")
+	}
+	if !c.isOriginAllowed("http://a.com", nil) {
+		t.Errorf("the current origins aren't kept")
+	}
+}
+
+func TestWildcardRequestHeaders(t *testing.T) {
+	var tests = []struct {
+		name           string
+		allowedHeaders string
+		reqHeaders     string
+		code           int
+		acah           string
+	}{
+		{"all headers allowed", "*", "*", http.StatusOK, "*"},
+		{"all headers allowed with others", "*", "X-Header-1, *", http.StatusOK, "X-Header-1, *"},
+		{"headers list", "X-Header-1", "*", http.StatusForbidden, ""},
+		{"headers list with wildcard", "X-Header-1,*", "X-Header-1, *", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				AllowedHeaders: tt.allowedHeaders,
+				Logger:         log.New(buf, "", 0),
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", tt.reqHeaders)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.acah {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.acah, actual)
+			}
+			if tt.code == http.StatusForbidden && !strings.Contains(buf.String(), "wildcard") {
+				t.Errorf("missing reason in the log %q", buf.String())
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	if c := initialize(Config{}); c.now == nil {
+		t.Fatalf("the default clock isn't set")
+	}
+
+	fixed := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := Config{Metrics: &countMetrics{}, MaxAge: 60}
+	config.setNow(func() time.Time { return fixed })
+	c := initialize(config)
+
+	if now := c.now(); !now.Equal(fixed) {
+		t.Errorf("got %v, want the injected time %v", now, fixed)
+	}
+
+	c.preflights.track("http://foobar.com", "GET")
+	fixed = fixed.Add(59 * time.Second)
+	if !c.preflights.track("http://foobar.com", "GET") {
+		t.Errorf("the preflight isn't repeated within the TTL")
+	}
+	fixed = fixed.Add(60 * time.Second)
+	if c.preflights.track("http://foobar.com", "GET") {
+		t.Errorf("the preflight is repeated after the TTL expiry")
+	}
+}
+
+func TestPreflightNotForwarded(t *testing.T) {
+	var tests = []struct {
+		name      string
+		forward   bool
+		reqMethod string
+		code      int
+		called    bool
+	}{
+		{"allowed", false, "GET", http.StatusOK, false},
+		{"rejected", false, "PUT", http.StatusMethodNotAllowed, false},
+		{"forwarded", true, "GET", http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				ForwardRequest: tt.forward,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.reqMethod)
+
+			f(next).ServeHTTP(res, req)
+
+			if called != tt.called {
+				t.Errorf("next called %v, want %v", called, tt.called)
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestApexOrigin(t *testing.T) {
+	var tests = []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://bar.com", true},
+		{"https://bar.com", true},
+		{"http://foo.bar.com", true},
+		{"http://a.b.bar.com", true},
+		{"http://foobar.com", false},
+		{"http://bar.com.evil.net", false},
+		{"http://bar.com:8080", false},
+		{"http://foo.qux.com:8080", true},
+		{"http://qux.com", false},
+		{"bar.com", false},
+		{"https://api.BAR.com", true},
+		{"HTTPS://Bar.Com", true},
+		{"https://shop.xn--mnchen-3ya.de", true},
+		{"https://xn--mnchen-3ya.de", true},
+	}
+
+	c := initialize(Config{
+		AllowedOrigins: ".bar.com,.qux.com:8080,.münchen.de",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("got %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+
+	// the schemes are restricted like the ones of the any scheme origins
+	c = initialize(Config{AllowedOrigins: ".bar.com", AllowedSchemes: "https"})
+	for origin, allowed := range map[string]bool{"https://api.bar.com": true, "http://api.bar.com": false, "javascript://bar.com": false} {
+		if c.isOriginAllowed(origin, nil) != allowed {
+			t.Errorf("origin %s with AllowedSchemes = https, want allowed %v", origin, allowed)
+		}
+	}
+
+	if p := strings.Join(New(Config{AllowedOrigins: ".bar.com"}).AllowedOriginPatterns(), ","); p != ".bar.com" {
+		t.Errorf("got the patterns %q, want .bar.com", p)
+	}
+	if err := ValidateConfig(Config{AllowedOrigins: ".bar.com"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestLowerCaseAllowedHeaders(t *testing.T) {
+	var tests = []struct {
+		name   string
+		lower  bool
+		legacy bool
+		acah   string
+	}{
+		{"original casing", false, false, "X-Header-1,Content-Type"},
+		{"lower case", true, false, "x-header-1,content-type"},
+		{"lower case with legacy headers", true, true, "x-header-1,content-type,x-requested-with"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:          "http://foobar.com",
+				AllowedHeaders:          "X-Header-1,Content-Type",
+				LowerCaseAllowedHeaders: tt.lower,
+				LegacyAJAXCompat:        tt.legacy,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", "X-Header-1")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.acah {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.acah, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestMaxOriginPatternSize(t *testing.T) {
+	oversized := "http://*." + strings.Repeat("a?", 600) + ".com"
+
+	var tests = []struct {
+		name    string
+		maxSize int
+		pattern string
+		origin  string
+		allowed bool
+	}{
+		{"default limit", 0, oversized, "http://x." + strings.Repeat("ab", 600) + ".com", false},
+		{"custom limit", 5000, oversized, "http://x." + strings.Repeat("ab", 600) + ".com", true},
+		{"small pattern", 15, "http://*.c", "http://x.c", true},
+		{"small limit", 15, "http://*.bar.com", "http://x.bar.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := initialize(Config{
+				AllowedOrigins:       tt.pattern + ",http://foobar.com",
+				MaxOriginPatternSize: tt.maxSize,
+				Logger:               log.New(buf, "", 0),
+			})
+
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("got %v, want %v", allowed, tt.allowed)
+			}
+			if logged := strings.Contains(buf.String(), "too complex"); logged == tt.allowed {
+				t.Errorf("got the log %q", buf.String())
+			}
+			if !c.isOriginAllowed("http://foobar.com", nil) {
+				t.Errorf("the other origins must be kept")
+			}
+		})
+	}
+}
+
+func TestPrivateNetworkOriginPatternSize(t *testing.T) {
+	config := Config{
+		AllowedOrigins:        "http://foobar.com",
+		PrivateNetworkOrigins: "http://*.bar.com",
+		MaxOriginPatternSize:  15,
+	}
+
+	buf := new(bytes.Buffer)
+	config.Logger = log.New(buf, "", 0)
+	c := initialize(config)
+
+	if c.privateNetworkOrigins.isAllowed(parseOrigin("http://x.bar.com"), nil) {
+		t.Errorf("the oversized private network pattern is allowed")
+	}
+	if !strings.Contains(buf.String(), "too complex") {
+		t.Errorf("got the log %q", buf.String())
+	}
+	// initialize and ValidateConfig agree
+	if err := ValidateConfig(config); err == nil {
+		t.Errorf("the oversized private network pattern is valid")
+	}
+}
+
+func TestOriginAliases(t *testing.T) {
+	var tests = []struct {
+		name   string
+		origin string
+		acao   string
+		code   int
+	}{
+		{"alias", "http://old.example.com", "http://app.example.com", http.StatusOK},
+		{"canonical", "http://app.example.com", "http://app.example.com", http.StatusOK},
+		{"other", "http://other.example.com", "", http.StatusForbidden},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins: "http://app.example.com",
+		OriginAliases: map[string]string{
+			"http://old.example.com": "http://app.example.com",
+		},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.acao {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.acao, actual)
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var tests = []struct {
+		name     string
+		level    LogLevel
+		method   string
+		origin   string
+		logged   bool
+		contains string
+	}{
+		{"default allowed", LogWarn, "GET", "http://foobar.com", false, ""},
+		{"default preflight", LogWarn, "OPTIONS", "http://foobar.com", false, ""},
+		{"default rejected", LogWarn, "GET", "http://bar.com", true, "not allowed"},
+		{"info preflight", LogInfo, "OPTIONS", "http://foobar.com", true, "Preflight request from"},
+		{"info allowed", LogInfo, "GET", "http://foobar.com", false, ""},
+		{"debug allowed", LogDebug, "GET", "http://foobar.com", true, "Request from"},
+		{"error rejected", LogError, "GET", "http://bar.com", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				LogLevel:       tt.level,
+				Logger:         log.New(buf, "", 0),
+			})
+			// ignore the startup lines
+			buf.Reset()
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if logged := buf.Len() > 0; logged != tt.logged {
+				t.Errorf("logged %v, want %v: %q", logged, tt.logged, buf.String())
+			}
+			if !strings.Contains(buf.String(), tt.contains) {
+				t.Errorf("the log %q doesn't contain %q", buf.String(), tt.contains)
+			}
+		})
+	}
+}
+
+func TestExcludedOrigins(t *testing.T) {
+	var tests = []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://foo.example.com", true},
+		{"https://api.example.com", true},
+		{"http://admin.example.com", false},
+		{"https://ADMIN.example.com", false},
+		{"http://beta.example.com", true},
+		{"https://beta.example.com", false},
+	}
+
+	c := initialize(Config{
+		AllowedOrigins: "*.example.com !admin.example.com,!https://beta.example.com",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("got %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+
+	if all := initialize(Config{AllowedOrigins: "* !http://evil.com"}); all.isOriginAllowed("http://evil.com", nil) || !all.isOriginAllowed("http://good.com", nil) {
+		t.Errorf("the exclusion doesn't apply to all origins")
+	}
+
+	if p := strings.Join(New(Config{AllowedOrigins: "*.example.com !admin.example.com"}).AllowedOriginPatterns(), ","); p != "*.example.com,!admin.example.com" {
+		t.Errorf("got the patterns %q", p)
+	}
+
+	if err := ValidateConfig(Config{AllowedOrigins: "*.example.com !admin.example.com"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestStrictMethodCase(t *testing.T) {
+	var tests = []struct {
+		name   string
+		strict bool
+		method string
+		code   int
+	}{
+		{"strict upper case", true, "PUT", http.StatusOK},
+		{"strict lower case", true, "put", http.StatusBadRequest},
+		{"strict mixed case", true, "Put", http.StatusBadRequest},
+		{"not strict lower case", false, "put", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AllowedMethods:   "GET,PUT,OPTIONS",
+				StrictMethodCase: tt.strict,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.method)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestPrivateCacheOnReflectVaries(t *testing.T) {
+	var tests = []struct {
+		name         string
+		origins      string
+		cacheControl string
+		want         string
+	}{
+		{"single static origin", "http://api.foobar.com", "public, max-age=60", "public, max-age=60"},
+		{"static origins", "http://api.foobar.com,http://bar.com", "public, max-age=60", "private, max-age=60"},
+		{"suffix origin", "*.foobar.com", "public, s-maxage=600, max-age=60", "private, max-age=60"},
+		{"no cache control", "*", "", "private"},
+		{"no-store", "*", "no-store", "no-store"},
+		{"already private", "*", "private, max-age=60", "private, max-age=60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:        tt.origins,
+				PrivateCacheOnReflect: true,
+			})
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				w.Write([]byte("test"))
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://api.foobar.com")
+
+			f(handler).ServeHTTP(res, req)
+
+			if actual := strings.Join(res.Header()["Cache-Control"], ", "); actual != tt.want {
+				t.Errorf("Invalid header `Cache-Control', wanted `%s', got `%s'", tt.want, actual)
+			}
+			assertResponse(t, res, http.StatusOK)
+		})
+	}
+}
+
+func TestVerifyOriginToken(t *testing.T) {
+	sign := func(origin string) string {
+		return strings.ToUpper(strings.TrimPrefix(origin, "http://")) + ".sig"
+	}
+
+	var tests = []struct {
+		name   string
+		method string
+		token  string
+		code   int
+	}{
+		{"valid token", "GET", sign("http://foobar.com"), http.StatusOK},
+		{"tampered token", "GET", sign("http://bar.com"), http.StatusForbidden},
+		{"missing token", "GET", "", http.StatusForbidden},
+		{"preflight", "OPTIONS", "", http.StatusOK},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:    "http://foobar.com",
+		AllowedHeaders:    "X-Origin-Token",
+		OriginTokenHeader: "X-Origin-Token",
+		VerifyOriginToken: func(token, origin string) bool {
+			return token == sign(origin)
+		},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			if tt.token != "" {
+				req.Header.Add("X-Origin-Token", tt.token)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestExposedHeadersWarnings(t *testing.T) {
+	var tests = []struct {
+		name           string
+		allowedHeaders string
+		exposedHeaders string
+		warning        string
+	}{
+		{"response header", "", "X-Header-1,ETag", ""},
+		{"request header", "", "X-Header-1,Authorization", "ExposedHeaders contains Authorization"},
+		{"request header allowed", "Authorization", "Authorization", ""},
+		{"all headers allowed", "*", "X-Requested-With", ""},
+		{"empty header", "", "X-Header-1,,ETag", "contains an empty header name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			Filter(Config{
+				AllowedHeaders: tt.allowedHeaders,
+				ExposedHeaders: tt.exposedHeaders,
+				Logger:         log.New(buf, "", 0),
+			})
+
+			warned := strings.Contains(buf.String(), "Warning")
+			if warned != (tt.warning != "") || !strings.Contains(buf.String(), tt.warning) {
+				t.Errorf("got the log %q, want the warning %q", buf.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestCredentialsWithWildcardHeaders(t *testing.T) {
+	var tests = []struct {
+		name           string
+		credentials    bool
+		reqHeaders     string
+		allowedHeaders string
+		warning        bool
+	}{
+		{"credentials", true, "X-Header-1, X-Header-2", "X-Header-1, X-Header-2", true},
+		{"credentials literal wildcard", true, "*", "*", true},
+		{"no credentials", false, "X-Header-1, X-Header-2", "X-Header-1, X-Header-2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			filter := Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AllowedHeaders:   "*",
+				AllowCredentials: tt.credentials,
+				Logger:           log.New(buf, "", 0),
+			})
+
+			if warned := strings.Contains(buf.String(), "Warning"); warned != tt.warning {
+				t.Errorf("got the log %q, wanted a warning: %v", buf.String(), tt.warning)
+			}
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", tt.reqHeaders)
+
+			filter(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.allowedHeaders {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.allowedHeaders, actual)
+			}
+			credentials := ""
+			if tt.credentials {
+				credentials = "true"
+			}
+			if actual := res.Header().Get("Access-Control-Allow-Credentials"); actual != credentials {
+				t.Errorf("Invalid header `Access-Control-Allow-Credentials', wanted `%s', got `%s'", credentials, actual)
+			}
+		})
+	}
+}
+
+func TestPrivateNetworkOrigins(t *testing.T) {
+	var tests = []struct {
+		name         string
+		origin       string
+		allowPrivate bool
+	}{
+		{"static origin", "http://intranet.foobar.com", true},
+		{"pattern origin", "http://app.corp.foobar.com", true},
+		{"public origin", "http://www.foobar.com", false},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:        "*.foobar.com",
+		PrivateNetworkOrigins: "http://intranet.foobar.com, http://*.corp.foobar.com",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Private-Network", "true")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			assertHeaders(t, res.Header(), map[string]string{
+				"Access-Control-Allow-Origin": tt.origin,
+				"Vary":                        "Access-Control-Request-Private-Network",
+			})
+			if tt.allowPrivate {
+				assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Private-Network": "true"})
+			} else {
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Private-Network")
+			}
+		})
+	}
+}
+
+func TestStripOriginOnForward(t *testing.T) {
+	var tests = []struct {
+		name   string
+		strip  bool
+		origin string
+	}{
+		{"strip", true, ""},
+		{"keep", false, "http://foobar.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwarded string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header.Get("Origin")
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			Filter(Config{
+				AllowedOrigins:       "http://foobar.com",
+				ForwardRequest:       true,
+				StripOriginOnForward: tt.strip,
+			})(next).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": "http://foobar.com"})
+			if forwarded != tt.origin {
+				t.Errorf("Invalid forwarded header `Origin', wanted `%s', got `%s'", tt.origin, forwarded)
+			}
+			if actual := req.Header.Get("Origin"); actual != "http://foobar.com" {
+				t.Errorf("the caller request is changed, got the header `Origin' `%s'", actual)
+			}
+		})
+	}
+}
+
+func TestDefaultOptionsHandler(t *testing.T) {
+	optionsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	var tests = []struct {
+		name    string
+		handler http.Handler
+		method  string
+		origin  string
+		code    int
+		allow   string
+	}{
+		{"options without origin", optionsHandler, "OPTIONS", "", http.StatusNoContent, "GET, POST, OPTIONS"},
+		{"options without origin, no handler", nil, "OPTIONS", "", http.StatusMethodNotAllowed, ""},
+		{"get without origin", optionsHandler, "GET", "", http.StatusOK, ""},
+		{"preflight", optionsHandler, "OPTIONS", "http://foobar.com", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			if tt.origin != "" {
+				req.Header.Add("Origin", tt.origin)
+				req.Header.Add("Access-Control-Request-Method", "GET")
+			}
+
+			Filter(Config{
+				AllowedOrigins:        "http://foobar.com",
+				DefaultOptionsHandler: tt.handler,
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Allow"); actual != tt.allow {
+				t.Errorf("Invalid header `Allow', wanted `%s', got `%s'", tt.allow, actual)
+			}
+		})
+	}
+}
+
+func TestCrossOriginResourcePolicy(t *testing.T) {
+	var tests = []struct {
+		name   string
+		policy string
+		origin string
+		corp   string
+	}{
+		{"same origin", CORPSameOrigin, "http://foobar.com", "same-origin"},
+		{"same site", CORPSameSite, "http://foobar.com", "same-site"},
+		{"cross origin", CORPCrossOrigin, "http://foobar.com", "cross-origin"},
+		{"no cors request", CORPSameSite, "", "same-site"},
+		{"invalid", "none", "http://foobar.com", ""},
+		{"not set", "", "http://foobar.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			if tt.origin != "" {
+				req.Header.Add("Origin", tt.origin)
+			}
+
+			Filter(Config{
+				AllowedOrigins:            "http://foobar.com",
+				CrossOriginResourcePolicy: tt.policy,
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Cross-Origin-Resource-Policy"); actual != tt.corp {
+				t.Errorf("Invalid header `Cross-Origin-Resource-Policy', wanted `%s', got `%s'", tt.corp, actual)
+			}
+		})
+	}
+}
+
+func TestSuffixOriginCaseInsensitive(t *testing.T) {
+	var tests = []struct {
+		name    string
+		pattern string
+		origin  string
+		allowed bool
+	}{
+		{"mixed case pattern", "*.Bar.com", "http://x.bar.com", true},
+		{"mixed case origin", "*.bar.com", "http://X.BAR.com", true},
+		{"mixed case scheme", "*.bar.com", "HTTP://x.bar.com", true},
+		{"mixed case both", "*.BaR.CoM", "https://x.bAr.com", true},
+		{"any scheme mixed case pattern", "*://*.Bar.com", "https://x.bar.com", true},
+		{"any scheme mixed case origin", "*://*.bar.com", "https://X.Bar.COM", true},
+		{"different suffix", "*.Bar.com", "http://x.bar.org", false},
+		{"suffix without the dot", "*.bar.com", "https://evilbar.com", false},
+		{"mixed case suffix without the dot", "*.bar.com", "https://EvilBar.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := initialize(Config{AllowedOrigins: tt.pattern})
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("origin %q with pattern %q, got allowed %v, want %v", tt.origin, tt.pattern, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestTruncateList(t *testing.T) {
+	var tests = []struct {
+		in  string
+		max int
+		out string
+	}{
+		{"X-Header-1,X-Header-2", 100, "X-Header-1,X-Header-2"},
+		{"X-Header-1,X-Header-2", 21, "X-Header-1,X-Header-2"},
+		{"X-Header-1,X-Header-2", 20, "X-Header-1"},
+		{"X-Header-1, X-Header-2", 11, "X-Header-1"},
+		{"X-Header-1,X-Header-2", 10, "X-Header-1"},
+		{"X-Header-1,X-Header-2", 9, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if actual := truncateList(tt.in, tt.max); actual != tt.out {
+				t.Errorf("truncateList(%q, %d), got %q, want %q", tt.in, tt.max, actual, tt.out)
+			}
+		})
+	}
+}
+
+func TestMaxResponseHeaderBytes(t *testing.T) {
+	var tests = []struct {
+		name           string
+		allowedHeaders string
+		reqHeaders     string
+		allowHeaders   string
+		exposeHeaders  string
+		warning        bool
+		code           int
+	}{
+		{"fits", "X-Header-1,X-Header-2", "X-Header-1", "X-Header-1,X-Header-2", "X-Exposed-1", false, http.StatusOK},
+		{"truncated", "X-Header-1,X-Header-2,X-Header-3,X-Header-4", "X-Header-1", "X-Header-1,X-Header-2", "X-Exposed-1", true, http.StatusOK},
+		{"all headers reflected", "*", "X-Header-1, X-Header-2", "X-Header-1, X-Header-2", "X-Exposed-1", false, http.StatusOK},
+		{"all headers, too long to reflect", "*", "X-Header-1, X-Header-2, X-Header-3", "", "X-Exposed-1", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			filter := Filter(Config{
+				AllowedOrigins:         "http://foobar.com",
+				AllowedHeaders:         tt.allowedHeaders,
+				ExposedHeaders:         "X-Exposed-1",
+				MaxResponseHeaderBytes: 24,
+				Logger:                 log.New(buf, "", 0),
+			})
+
+			if warned := strings.Contains(buf.String(), "Warning"); warned != tt.warning {
+				t.Errorf("got the log %q, wanted a warning: %v", buf.String(), tt.warning)
+			}
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			req.Header.Add("Access-Control-Request-Headers", tt.reqHeaders)
+
+			buf.Reset()
+			filter(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if logged := strings.Contains(buf.String(), "exceed MaxResponseHeaderBytes"); logged != (tt.code != http.StatusOK) {
+				t.Errorf("got the log %q for the preflight request", buf.String())
+			}
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.allowHeaders {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.allowHeaders, actual)
+			}
+
+			res = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			filter(testHandler).ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Expose-Headers"); actual != tt.exposeHeaders {
+				t.Errorf("Invalid header `Access-Control-Expose-Headers', wanted `%s', got `%s'", tt.exposeHeaders, actual)
+			}
+		})
+	}
+}
+
+func TestRequireBothMatchers(t *testing.T) {
+	var tests = []struct {
+		name        string
+		origin      string
+		requireBoth bool
+		allowed     bool
+		consulted   bool
+	}{
+		{"both pass", "http://app.foobar.com", true, true, true},
+		{"second stage fails", "http://old.foobar.com", true, false, true},
+		{"first stage fails", "http://app.bar.com", true, false, false},
+		{"first stage fails, any stage", "http://app.bar.com", false, true, true},
+		{"second stage fails, any stage", "http://old.foobar.com", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consulted := false
+			c := initialize(Config{
+				AllowedOrigins: "http://*.foobar.com",
+				AllowOriginFunc: func(origin string) bool {
+					consulted = true
+					return origin != "http://old.foobar.com"
+				},
+				RequireBothMatchers: tt.requireBoth,
+			})
+
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("origin %q, got allowed %v, want %v", tt.origin, allowed, tt.allowed)
+			}
+			if consulted != tt.consulted {
+				t.Errorf("origin %q, got AllowOriginFunc consulted %v, want %v", tt.origin, consulted, tt.consulted)
+			}
+		})
+	}
+}
+
+func TestExposedHeadersNormalized(t *testing.T) {
+	var tests = []struct {
+		name           string
+		exposedHeaders string
+		expected       string
+		logged         string
+	}{
+		{"valid", "X-Header-1,ETag", "X-Header-1,ETag", ""},
+		{"spaces", " X-Header-1 , ETag ", "X-Header-1,ETag", ""},
+		{"space in name", "X-Header-1,Content Type", "X-Header-1", `Error: ignore the exposed header "Content Type"`},
+		{"separator in name", "X-Header-1:ETag", "", `Error: ignore the exposed header "X-Header-1:ETag"`},
+		{"empty name", "X-Header-1,,ETag", "X-Header-1,ETag", "Warning"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com",
+				ExposedHeaders: tt.exposedHeaders,
+				Logger:         log.New(buf, "", 0),
+			})
+
+			if tt.logged != "" && !strings.Contains(buf.String(), tt.logged) {
+				t.Errorf("got the log %q, want %q", buf.String(), tt.logged)
+			}
+			if tt.logged == "" && (strings.Contains(buf.String(), "Error") || strings.Contains(buf.String(), "Warning")) {
+				t.Errorf("unexpected log %q", buf.String())
+			}
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Access-Control-Expose-Headers"); actual != tt.expected {
+				t.Errorf("Invalid header `Access-Control-Expose-Headers', wanted `%s', got `%s'", tt.expected, actual)
+			}
+			if tt.expected == "" {
+				assertNoHeaders(t, res.Header(), "Access-Control-Expose-Headers")
+			}
+		})
+	}
+}
+
+func TestPortRangeOrigin(t *testing.T) {
+	var tests = []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"first port", "http://localhost:8000", true},
+		{"in range", "http://localhost:8080", true},
+		{"last port", "http://localhost:8999", true},
+		{"below range", "http://localhost:7999", false},
+		{"above range", "http://localhost:9000", false},
+		{"no port", "http://localhost", false},
+		{"other scheme", "https://localhost:8080", false},
+		{"other host", "http://127.0.0.1:8080", false},
+		{"upper case host", "http://LOCALHOST:8080", true},
+		{"invalid port", "http://localhost:80a0", false},
+	}
+
+	c := initialize(Config{AllowedOrigins: "http://localhost:8000-8999"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := c.isOriginAllowed(tt.origin, nil); allowed != tt.allowed {
+				t.Errorf("origin %q, got allowed %v, want %v", tt.origin, allowed, tt.allowed)
+			}
+		})
+	}
+
+	if p := c.getOrigins().patterns(); len(p) != 1 || p[0] != "http://localhost:8000-8999" {
+		t.Errorf("got the patterns %v, want [http://localhost:8000-8999]", p)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	var tests = []struct {
+		origin string
+		ok     bool
+	}{
+		{"http://localhost:8000-8999", true},
+		{"http://[::1]:3000-3010", true},
+		{"http://localhost:8000", false},
+		{"http://localhost:8999-8000", false},
+		{"http://localhost:0-10", false},
+		{"http://localhost:8000-70000", false},
+		{"http://localhost:a-b", false},
+		{"localhost:8000-8999", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if _, ok := parsePortRange(tt.origin); ok != tt.ok {
+				t.Errorf("parsePortRange(%q), got %v, want %v", tt.origin, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMaxOriginLength(t *testing.T) {
+	// "http://" + host + ".com"
+	origin := func(n int) string {
+		return "http://" + strings.Repeat("a", n-len("http://")-len(".com")) + ".com"
+	}
+
+	var tests = []struct {
+		name      string
+		maxLength int
+		origin    string
+		code      int
+	}{
+		{"default at the limit", 0, origin(DefaultMaxOriginLength), http.StatusOK},
+		{"default above the limit", 0, origin(DefaultMaxOriginLength + 1), http.StatusForbidden},
+		{"at the limit", 32, origin(32), http.StatusOK},
+		{"above the limit", 32, origin(33), http.StatusForbidden},
+		{"multi kilobyte", 32, origin(8192), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched := false
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			Filter(Config{
+				AllowOriginFunc: func(origin string) bool {
+					matched = true
+					return true
+				},
+				MaxOriginLength: tt.maxLength,
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if matched != (tt.code == http.StatusOK) {
+				t.Errorf("origin of %d bytes, got matched %v", len(tt.origin), matched)
+			}
+		})
+	}
+}
+
+func TestForwardDisallowedPreflight(t *testing.T) {
+	var tests = []struct {
+		name      string
+		forward   bool
+		reqMethod string
+		reqHeader string
+		code      int
+		forwarded bool
+	}{
+		{"method not allowed", false, "PUT", "", http.StatusMethodNotAllowed, false},
+		{"method not allowed, forwarded", true, "PUT", "", http.StatusMethodNotAllowed, true},
+		{"header not allowed, forwarded", true, "GET", "X-Header-1", http.StatusForbidden, true},
+		{"allowed, forwarded", true, "GET", "", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				// the rejection status wins
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("rejected"))
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.reqMethod)
+			if tt.reqHeader != "" {
+				req.Header.Add("Access-Control-Request-Headers", tt.reqHeader)
+			}
+
+			Filter(Config{
+				AllowedOrigins:             "http://foobar.com",
+				ForwardDisallowedPreflight: tt.forward,
+			})(next).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if forwarded != tt.forwarded {
+				t.Errorf("got forwarded %v, want %v", forwarded, tt.forwarded)
+			}
+		})
+	}
+}
+
+func TestNormalizeReflectedOrigin(t *testing.T) {
+	var tests = []struct {
+		name      string
+		normalize bool
+		origin    string
+		reflected string
+	}{
+		{"https default port", true, "https://foo.com:443", "https://foo.com"},
+		{"http default port", true, "http://foo.com:80", "http://foo.com"},
+		{"https non default port", true, "https://foo.com:8443", "https://foo.com:8443"},
+		{"http port of https", true, "http://foo.com:443", "http://foo.com:443"},
+		{"no port", true, "https://foo.com", "https://foo.com"},
+		{"ipv6 default port", true, "https://[::1]:443", "https://[::1]"},
+		{"not normalized", false, "https://foo.com:443", "https://foo.com:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			Filter(Config{
+				AllowOriginFunc:          func(origin string) bool { return true },
+				NormalizeReflectedOrigin: tt.normalize,
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.reflected {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.reflected, actual)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedAllowHeader(t *testing.T) {
+	var tests = []struct {
+		name     string
+		methods  string
+		provider []string
+		method   string
+		code     int
+		allow    string
+	}{
+		{"default methods", "", nil, "PUT", http.StatusMethodNotAllowed, DefaultAllowedMethods},
+		{"custom methods", "GET,DELETE", nil, "PUT", http.StatusMethodNotAllowed, "GET,DELETE"},
+		{"lower case methods", "get,delete", nil, "PUT", http.StatusMethodNotAllowed, "GET,DELETE"},
+		{"methods of the resource", "GET,POST,DELETE,OPTIONS", []string{"get", "DELETE", "PATCH"}, "PUT", http.StatusMethodNotAllowed, "GET,DELETE"},
+		{"allowed method", "", nil, "GET", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			config := Config{
+				AllowedOrigins: "http://foobar.com",
+				AllowedMethods: tt.methods,
+			}
+			if tt.provider != nil {
+				config.MethodsProvider = func(r *http.Request) []string {
+					return tt.provider
+				}
+			}
+			Filter(config)(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Allow"); actual != tt.allow {
+				t.Errorf("Invalid header `Allow', wanted `%s', got `%s'", tt.allow, actual)
+			}
+		})
+	}
+}
+
+func TestServerErrorHeaders(t *testing.T) {
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	var tests = []struct {
+		name     string
+		suppress bool
+		method   string
+		origin   string
+	}{
+		{"actual request", false, "GET", "http://foobar.com"},
+		{"forwarded preflight", false, "OPTIONS", "http://foobar.com"},
+		{"actual request suppressed", true, "GET", ""},
+		{"forwarded preflight suppressed", true, "OPTIONS", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			if tt.method == "OPTIONS" {
+				req.Header.Add("Access-Control-Request-Method", "GET")
+			}
+
+			Filter(Config{
+				AllowedOrigins:               "http://foobar.com",
+				ExposedHeaders:               "X-Header-1",
+				AllowCredentials:             true,
+				ForwardRequest:               true,
+				SuppressHeadersOnServerError: tt.suppress,
+			})(failing).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusInternalServerError)
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.origin {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.origin, actual)
+			}
+			if tt.suppress {
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers", "Access-Control-Allow-Methods")
+			} else {
+				assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Credentials": "true"})
+			}
+			assertHeaders(t, res.Header(), map[string]string{"Vary": "Origin"})
+		})
+	}
+}
+
+func TestOriginClaimVerifier(t *testing.T) {
+	// the partner token is the name of the partner
+	claims := map[string][]string{
+		"acme":    {"https://acme.com", "https://app.acme.com"},
+		"initech": {"https://initech.com:443"},
+	}
+	verifier := func(r *http.Request) ([]string, error) {
+		origins, ok := claims[r.Header.Get("X-Partner-Token")]
+		if !ok {
+			return nil, errors.New("invalid token")
+		}
+		return origins, nil
+	}
+
+	var tests = []struct {
+		name      string
+		method    string
+		token     string
+		origin    string
+		code      int
+		preflight bool // VerifyClaimsOnPreflight
+	}{
+		{"claimed origin", "GET", "acme", "https://app.acme.com", http.StatusOK, false},
+		{"other claimed origin", "GET", "acme", "https://acme.com", http.StatusOK, false},
+		{"origin of another partner", "GET", "acme", "https://initech.com", http.StatusForbidden, false},
+		{"claimed origin with default port", "GET", "initech", "https://initech.com", http.StatusOK, false},
+		{"verifier error", "GET", "unknown", "https://acme.com", http.StatusForbidden, false},
+		{"static origin", "GET", "", "http://foobar.com", http.StatusOK, false},
+		{"preflight without token", "OPTIONS", "", "https://acme.com", http.StatusOK, false},
+		{"actual request without token", "GET", "", "https://acme.com", http.StatusForbidden, false},
+		{"preflight verified", "OPTIONS", "", "https://acme.com", http.StatusForbidden, true},
+		{"preflight verified with token", "OPTIONS", "acme", "https://acme.com", http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:          "http://foobar.com",
+				OriginClaimVerifier:     verifier,
+				VerifyClaimsOnPreflight: tt.preflight,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			if tt.token != "" {
+				req.Header.Add("X-Partner-Token", tt.token)
+			}
+			if tt.method == "OPTIONS" {
+				req.Header.Add("Access-Control-Request-Method", "GET")
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if tt.code == http.StatusOK {
+				assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": tt.origin})
+			}
+		})
+	}
+}
+
+func TestDeprecatedOrigins(t *testing.T) {
+	var tests = []struct {
+		name    string
+		method  string
+		origin  string
+		warning string
+	}{
+		{"deprecated static origin", "GET", "http://old.foobar.com", `299 - "old.foobar.com is deprecated, use app.foobar.com"`},
+		{"deprecated pattern origin", "GET", "http://x.legacy.com", `299 - "legacy.com will be removed"`},
+		{"deprecated origin preflight", "OPTIONS", "http://old.foobar.com", `299 - "old.foobar.com is deprecated, use app.foobar.com"`},
+		{"current origin", "GET", "http://app.foobar.com", ""},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins: "http://*.foobar.com, http://*.legacy.com",
+		DeprecatedOrigins: map[string]string{
+			"http://old.foobar.com": "old.foobar.com is deprecated, use app.foobar.com",
+			"http://*.legacy.com":   "legacy.com will be removed",
+		},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			// still served
+			assertResponse(t, res, http.StatusOK)
+			assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": tt.origin})
+			if actual := res.Header().Get("Warning"); actual != tt.warning {
+				t.Errorf("Invalid header `Warning', wanted `%s', got `%s'", tt.warning, actual)
+			}
+		})
+	}
+}
+
+func TestAllowedHeadersByMethod(t *testing.T) {
+	var tests = []struct {
+		name         string
+		reqMethod    string
+		reqHeaders   string
+		code         int
+		allowHeaders string
+	}{
+		{"idempotency key on put", "PUT", "Content-Type, X-Idempotency-Key", http.StatusOK, "Content-Type,X-Idempotency-Key"},
+		{"idempotency key on get", "GET", "X-Idempotency-Key", http.StatusForbidden, ""},
+		{"global header on get", "GET", "X-Header-1", http.StatusOK, "X-Header-1"},
+		{"global header on put", "PUT", "X-Header-1", http.StatusForbidden, ""},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		AllowedMethods: "GET,PUT,OPTIONS",
+		AllowedHeaders: "X-Header-1",
+		AllowedHeadersByMethod: map[string]string{
+			"put": "Content-Type,X-Idempotency-Key",
+		},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.reqMethod)
+			req.Header.Add("Access-Control-Request-Headers", tt.reqHeaders)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.allowHeaders {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.allowHeaders, actual)
+			}
+		})
+	}
+}
+
+func TestOriginTemplate(t *testing.T) {
+	var tests = []struct {
+		name        string
+		template    string
+		credentials bool
+		reflected   string
+	}{
+		{"default", "", false, "http://foobar.com"},
+		{"placeholder only", "{origin}", false, "http://foobar.com"},
+		{"custom", "{origin} gateway=edge", false, "http://foobar.com gateway=edge"},
+		{"custom with credentials", "{origin} gateway=edge", true, "http://foobar.com gateway=edge"},
+		{"wildcard with credentials", "*", true, "http://foobar.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			Filter(Config{
+				AllowedOrigins:   "http://foobar.com",
+				AllowCredentials: tt.credentials,
+				OriginTemplate:   tt.template,
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Access-Control-Allow-Origin"); actual != tt.reflected {
+				t.Errorf("Invalid header `Access-Control-Allow-Origin', wanted `%s', got `%s'", tt.reflected, actual)
+			}
+		})
+	}
+}
+
+func TestRequireTLSForCredentials(t *testing.T) {
+	var tests = []struct {
+		name        string
+		requireTLS  bool
+		tls         bool
+		credentials string
+	}{
+		{"http", true, false, ""},
+		{"https", true, true, "true"},
+		{"http not required", false, false, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			f := Filter(Config{
+				AllowedOrigins:           "https://foobar.com",
+				AllowCredentials:         true,
+				RequireTLSForCredentials: tt.requireTLS,
+				Logger:                   log.New(buf, "", 0),
+			})
+
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+			if tt.tls {
+				req = httptest.NewRequest("GET", "https://example.com/foo", nil)
+			}
+			req.Header.Add("Origin", "https://foobar.com")
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": "https://foobar.com"})
+			if actual := res.Header().Get("Access-Control-Allow-Credentials"); actual != tt.credentials {
+				t.Errorf("Invalid header `Access-Control-Allow-Credentials', wanted `%s', got `%s'", tt.credentials, actual)
+			}
+			if logged := strings.Contains(buf.String(), "isn't over TLS"); logged != (tt.credentials == "") {
+				t.Errorf("got the log %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	reports := make(chan ViolationReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report ViolationReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("Invalid report, %v", err)
+		}
+		reports <- report
+	}))
+	defer server.Close()
+
+	f := Filter(Config{
+		AllowedOrigins:   "http://foobar.com",
+		AllowCredentials: true,
+		ReportOnly:       true,
+		ReportURI:        server.URL,
+		Logger:           log.New(new(bytes.Buffer), "", 0),
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo?token=secret", nil)
+	req.Header.Add("Origin", "http://bar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertResponse(t, res, http.StatusOK)
+	assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": "http://bar.com"})
+	assertNoHeaders(t, res.Header(), "Access-Control-Allow-Credentials")
+
+	select {
+	case report := <-reports:
+		want := ViolationReport{Type: "cors-violation", Origin: "http://bar.com", Method: "GET", URL: "http://example.com/foo", Reason: "origin_not_allowed"}
+		if report != want {
+			t.Errorf("Invalid report, wanted %+v, got %+v", want, report)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no report sent")
+	}
+
+	// an allowed origin isn't reported
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertResponse(t, res, http.StatusOK)
+	assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": "http://foobar.com", "Access-Control-Allow-Credentials": "true"})
+	select {
+	case report := <-reports:
+		t.Errorf("unexpected report %+v", report)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReportDropped(t *testing.T) {
+	release := make(chan bool)
+	received := make(chan bool, 2*maxPendingReports)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		received <- true
+	}))
+	defer server.Close()
+
+	buf := new(bytes.Buffer)
+	f := Filter(Config{
+		AllowedOrigins: "http://foobar.com",
+		ReportOnly:     true,
+		ReportURI:      server.URL,
+		Logger:         log.New(buf, "", 0),
+	})
+
+	for i := 0; i < maxPendingReports+3; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Add("Origin", "http://bar.com")
+
+		f(testHandler).ServeHTTP(res, req)
+
+		assertResponse(t, res, http.StatusOK)
+	}
+	close(release)
+
+	for i := 0; i < maxPendingReports; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("got %d reports, want %d", i, maxPendingReports)
+		}
+	}
+	select {
+	case <-received:
+		t.Errorf("more than %d reports sent", maxPendingReports)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := strings.Count(buf.String(), "violation report dropped"); n != 3 {
+		t.Errorf("got %d dropped reports, want 3, log %q", n, buf.String())
+	}
+}
+
+// chanWriter send each written log line to the channel
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestReportNotSentLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	logs := make(chanWriter, 16)
+	f := Filter(Config{
+		AllowedOrigins:    "http://foobar.com",
+		ReportOnly:        true,
+		ReportURI:         server.URL,
+		CorrelationHeader: "X-Request-Id",
+		Logger:            log.New(logs, "", 0),
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://bar.com")
+	req.Header.Add("X-Request-Id", "abc")
+
+	f(testHandler).ServeHTTP(res, req)
+	// the request is reused once the handler returned, the report must not read it
+	req.Header.Set("X-Request-Id", "changed")
+
+	for {
+		select {
+		case line := <-logs:
+			if strings.Contains(line, "violation report not sent") {
+				if !strings.Contains(line, "[abc] ") {
+					t.Errorf("got the log %q, want the correlation id abc", line)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the report failure isn't logged")
+		}
+	}
+}
+
+func TestPreflightRejectStatus(t *testing.T) {
+	var tests = []struct {
+		name          string
+		methodStatus  int
+		headerStatus  int
+		requestMethod string
+		requestHeader string
+		code          int
+	}{
+		{"method default", 0, 0, "PUT", "", http.StatusMethodNotAllowed},
+		{"header default", 0, 0, "GET", "X-Header-2", http.StatusForbidden},
+		{"method custom", http.StatusBadRequest, 0, "PUT", "", http.StatusBadRequest},
+		{"header custom", 0, http.StatusBadRequest, "GET", "X-Header-2", http.StatusBadRequest},
+		{"method custom, header rejected", http.StatusBadRequest, 0, "GET", "X-Header-2", http.StatusForbidden},
+		{"invalid custom status", http.StatusOK, 0, "PUT", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", tt.requestMethod)
+			if len(tt.requestHeader) > 0 {
+				req.Header.Add("Access-Control-Request-Headers", tt.requestHeader)
+			}
+
+			Filter(Config{
+				AllowedOrigins:              "http://foobar.com",
+				AllowedHeaders:              "X-Header-1",
+				PreflightMethodRejectStatus: tt.methodStatus,
+				PreflightHeaderRejectStatus: tt.headerStatus,
+				Logger:                      log.New(new(bytes.Buffer), "", 0),
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			assertNoHeaders(t, res.Header(), "Access-Control-Allow-Methods", "Access-Control-Allow-Headers")
+		})
+	}
+}
+
+func TestWarmupMatchers(t *testing.T) {
+	c := initialize(Config{
+		AllowedOrigins: "http://*.foobar.*",
+		WarmupMatchers: true,
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	for origin, want := range map[string]originMatch{"http://api.foobar.com": matchPattern, warmupOrigin: matchNone} {
+		if m := c.matchOrigin(parseOrigin(origin), req); m != want {
+			t.Errorf("Invalid match of %s, wanted %v, got %v", origin, want, m)
+		}
+	}
+}
+
+func TestInternationalizedSuffix(t *testing.T) {
+	var tests = []struct {
+		name    string
+		allowed string
+		origin  string
+		want    originMatch
+	}{
+		{"unicode suffix, punycode origin", "*.münchen.de", "http://shop.xn--mnchen-3ya.de", matchPattern},
+		{"unicode suffix, upper case punycode origin", "*.MÜNCHEN.de", "https://SHOP.XN--MNCHEN-3YA.DE", matchPattern},
+		{"unicode suffix, unicode origin", "*.münchen.de", "http://shop.münchen.de", matchPattern},
+		{"punycode suffix, unicode origin", "*.xn--mnchen-3ya.de", "http://shop.münchen.de", matchPattern},
+		{"any scheme unicode suffix", "*://*.münchen.de", "https://shop.xn--mnchen-3ya.de", matchPattern},
+		{"other unicode domain", "*.münchen.de", "http://shop.xn--bcher-kva.de", matchNone},
+		{"plain ascii domain", "*.münchen.de", "http://shop.munchen.de", matchNone},
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := initialize(Config{AllowedOrigins: tt.allowed})
+			if m := c.matchOrigin(parseOrigin(tt.origin), req); m != tt.want {
+				t.Errorf("Invalid match of %s with %s, wanted %v, got %v", tt.origin, tt.allowed, tt.want, m)
+			}
+		})
+	}
+}
+
+func TestHeadersSetOnce(t *testing.T) {
+	f := Filter(Config{
+		AllowedOrigins:      "http://foobar.com",
+		AllowedHeaders:      "X-Header-1",
+		ExposedHeaders:      "X-Header-2",
+		AllowCredentials:    true,
+		AllowPrivateNetwork: true,
+		ForwardRequest:      true,
+	})
+
+	var tests = []struct {
+		name    string
+		method  string
+		headers []string
+	}{
+		{"actual request", "GET", []string{AccessControlAllowOrigin, AccessControlAllowCredentials, AccessControlExposeHeaders}},
+		{"preflight request", "OPTIONS", []string{AccessControlAllowOrigin, AccessControlAllowCredentials, AccessControlAllowMethods,
+			AccessControlAllowHeaders, AccessControlControlMaxAge, AccessControlAllowPrivateNetwork}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			if tt.method == "OPTIONS" {
+				req.Header.Add("Access-Control-Request-Method", "GET")
+				req.Header.Add("Access-Control-Request-Headers", "X-Header-1")
+				req.Header.Add("Access-Control-Request-Private-Network", "true")
+			}
+
+			// the filter runs twice on the same response
+			f(testHandler).ServeHTTP(res, req)
+			f(testHandler).ServeHTTP(res, req)
+
+			for _, name := range tt.headers {
+				if values := res.Header()[name]; len(values) != 1 {
+					t.Errorf("Invalid header `%s', wanted once, got %q", name, values)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterTwice(t *testing.T) {
+	var tests = []struct {
+		name    string
+		method  string
+		headers map[string]string
+	}{
+		{"actual request", "GET", map[string]string{
+			"Vary":                             "Origin",
+			"Warning":                          `299 - "use https://foobar.com"`,
+			"Access-Control-Allow-Origin":      "http://foobar.com",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+		{"preflight request", "OPTIONS", map[string]string{
+			"Vary":                             "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			"Warning":                          `299 - "use https://foobar.com"`,
+			"Access-Control-Allow-Origin":      "http://foobar.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     DefaultAllowedMethods,
+			"Access-Control-Max-Age":           "1800",
+		}},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:    "http://foobar.com",
+		AllowCredentials:  true,
+		ForwardRequest:    true,
+		DeprecatedOrigins: map[string]string{"http://foobar.com": "use https://foobar.com"},
+		Logger:            log.New(new(bytes.Buffer), "", 0),
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(f(testHandler)).ServeHTTP(res, req)
+			f(testHandler).ServeHTTP(res, req)
+
+			for name, value := range tt.headers {
+				if actual := strings.Join(res.Header()[name], ", "); actual != value {
+					t.Errorf("Invalid header `%s', wanted `%s', got `%s'", name, value, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestUseFetchMetadata(t *testing.T) {
+	var tests = []struct {
+		name      string
+		method    string
+		fetchSite string
+		origin    string
+		token     string
+		code      int
+		cors      bool
+	}{
+		{"same origin", "POST", "same-origin", "http://example.com", "secret", http.StatusOK, false},
+		{"same origin allowed", "POST", "same-origin", "http://foobar.com", "secret", http.StatusOK, false},
+		{"same origin not allowed", "POST", "same-origin", "http://bar.com", "secret", http.StatusForbidden, false},
+		{"same origin without token", "POST", "same-origin", "http://example.com", "", http.StatusForbidden, false},
+		{"user initiated", "GET", "none", "http://example.com", "secret", http.StatusOK, false},
+		{"user initiated without token", "POST", "none", "http://foobar.com", "", http.StatusForbidden, false},
+		{"same origin method not allowed", "PUT", "same-origin", "http://example.com", "secret", http.StatusMethodNotAllowed, false},
+		{"same site allowed", "GET", "same-site", "http://foobar.com", "secret", http.StatusOK, true},
+		{"cross site allowed", "GET", "cross-site", "http://foobar.com", "secret", http.StatusOK, true},
+		{"cross site not allowed", "GET", "cross-site", "http://bar.com", "", http.StatusForbidden, false},
+		{"old browser", "GET", "", "http://foobar.com", "secret", http.StatusOK, true},
+		{"old browser not allowed", "GET", "", "http://bar.com", "", http.StatusForbidden, false},
+	}
+
+	f := Filter(Config{
+		AllowedOrigins:    "http://foobar.com",
+		UseFetchMetadata:  true,
+		OriginTokenHeader: "X-Origin-Token",
+		VerifyOriginToken: func(token, origin string) bool {
+			return token == "secret"
+		},
+		Logger: log.New(new(bytes.Buffer), "", 0),
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			if len(tt.fetchSite) > 0 {
+				req.Header.Add("Sec-Fetch-Site", tt.fetchSite)
+			}
+			if len(tt.token) > 0 {
+				req.Header.Add("X-Origin-Token", tt.token)
+			}
+
+			f(handler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if tt.cors {
+				assertHeaders(t, res.Header(), map[string]string{"Access-Control-Allow-Origin": tt.origin})
+			} else {
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+			}
+			// the response varies on both headers, a shared cache must not mix them
+			if actual := strings.Join(res.Header()["Vary"], ", "); actual != "Origin, Sec-Fetch-Site" {
+				t.Errorf("Invalid header `Vary', wanted `Origin, Sec-Fetch-Site', got `%s'", actual)
+			}
+		})
+	}
+}
+
+func TestOmitSafelistedHeaders(t *testing.T) {
+	var tests = []struct {
+		name      string
+		omit      bool
+		reqHeader string
+		code      int
+		allowed   string
+	}{
+		{"omitted", true, "Accept", http.StatusOK, "Origin,Content-Type,Last-Event-ID,X-Header-1"},
+		{"omitted, still accepted", true, "Accept-Language, Content-Type, X-Header-1", http.StatusOK, "Origin,Content-Type,Last-Event-ID,X-Header-1"},
+		{"not omitted", false, "Accept", http.StatusOK, DefaultAllowedHeaders + ",X-Header-1"},
+		{"omitted, not allowed", true, "X-Header-2", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "POST")
+			req.Header.Add("Access-Control-Request-Headers", tt.reqHeader)
+
+			Filter(Config{
+				AllowedOrigins:        "http://foobar.com",
+				AllowedHeaders:        DefaultAllowedHeaders + ",X-Header-1",
+				OmitSafelistedHeaders: tt.omit,
+				Logger:                log.New(new(bytes.Buffer), "", 0),
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Access-Control-Allow-Headers"); actual != tt.allowed {
+				t.Errorf("Invalid header `Access-Control-Allow-Headers', wanted `%s', got `%s'", tt.allowed, actual)
+			}
+		})
+	}
+}
+
+// parseConfigFields parse the key=value pairs of the startup log line
+func parseConfigFields(t *testing.T, line string) map[string]string {
+	fields := make(map[string]string)
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			t.Fatalf("Invalid field in %q", line)
+		}
+		key, rest := line[:i], line[i+1:]
+
+		end := strings.IndexByte(rest, ' ')
+		if strings.HasPrefix(rest, `"`) {
+			// the closing quote, skipping the escaped ones
+			for end = 1; end < len(rest) && rest[end] != '"'; end++ {
+				if rest[end] == '\\' {
+					end++
+				}
+			}
+			end++
+		}
+		if end < 0 || end > len(rest) {
+			end = len(rest)
+		}
+
+		value := rest[:end]
+		if strings.HasPrefix(value, `"`) {
+			var err error
+			if value, err = strconv.Unquote(value); err != nil {
+				t.Fatalf("Invalid quoted value %q, %v", rest[:end], err)
+			}
+		}
+		fields[key] = value
+		line = rest[end:]
+	}
+
+	return fields
+}
+
+func TestStartupLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	initialize(Config{
+		AllowedOrigins:   "http://foobar.com, https://*.bar.com",
+		AllowedMethods:   "GET,PUT,OPTIONS",
+		AllowedHeaders:   "X-Header-1",
+		ExposedHeaders:   "X-Header-2, X-Header-3",
+		AllowCredentials: true,
+		MaxAge:           600,
+		Logger:           log.New(buf, "", 0),
+	})
+
+	const prefix = "[cors] Filter configuration "
+	var line string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(l, prefix) {
+			line = l[len(prefix):]
+		}
+	}
+	if line == "" {
+		t.Fatalf("no startup line logged, got %q", buf.String())
+	}
+
+	fields := parseConfigFields(t, line)
+	want := map[string]string{
+		"origins":         `http://foobar.com,regexp:^https://[^/?#@]*\.bar\.com$`,
+		"methods":         "GET,OPTIONS,PUT",
+		"headers":         "x-header-1",
+		"exposed_headers": "X-Header-2,X-Header-3",
+		"credentials":     "true",
+		"max_age":         "600",
+		"forward_request": "false",
+	}
+	for k, v := range want {
+		if actual, ok := fields[k]; !ok || actual != v {
+			t.Errorf("Invalid startup field `%s', wanted `%s', got `%s' in %q", k, v, actual, line)
+		}
+	}
+	if fields["version"] == "" {
+		t.Errorf("no version in %q", line)
+	}
+
+	// the empty values are quoted
+	if fields := parseConfigFields(t, initialize(Config{}).configFields()); fields["exposed_headers"] != "" || fields["credentials"] != "false" {
+		t.Errorf("Invalid startup fields %+v", fields)
+	}
+}
+
+func TestOmitSameOriginVary(t *testing.T) {
+	var tests = []struct {
+		name   string
+		skip   bool
+		omit   bool
+		origin string
+		vary   string
+	}{
+		{"same origin", true, true, "http://example.com", ""},
+		{"same origin, vary kept", true, false, "http://example.com", "Origin"},
+		{"same origin, without SkipSameOrigin", false, true, "http://example.com", "Origin"},
+		{"cross origin", true, true, "http://foobar.com", "Origin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:     "http://foobar.com,http://example.com",
+				AllowedMethods:     DefaultAllowedMethods,
+				SkipSameOrigin:     tt.skip,
+				OmitSameOriginVary: tt.omit,
+			})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(handler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusCreated)
+			if actual := strings.Join(res.Header()["Vary"], ", "); actual != tt.vary {
+				t.Errorf("Invalid header `Vary', wanted `%s', got `%s'", tt.vary, actual)
+			}
+		})
+	}
+}
+
+func TestMethodAdvertisement(t *testing.T) {
+	var tests = []struct {
+		name          string
+		advertisement MethodAdvertisement
+		options       bool
+		provider      []string
+		methods       string
+	}{
+		{"full", AdvertiseFull, false, []string{"GET", "PUT", "OPTIONS"}, "GET,PUT,OPTIONS"},
+		{"full without options", AdvertiseFull, false, []string{"GET", "PUT"}, "GET,PUT"},
+		{"reflect request", AdvertiseReflectRequest, false, []string{"GET", "PUT", "OPTIONS"}, "PUT"},
+		{"full plus options", AdvertiseFullPlusOptions, false, []string{"GET", "PUT"}, "GET,PUT,OPTIONS"},
+		{"full plus options already there", AdvertiseFullPlusOptions, false, []string{"GET", "PUT", "OPTIONS"}, "GET,PUT,OPTIONS"},
+		{"AdvertiseOptions", AdvertiseFull, true, []string{"GET", "PUT"}, "GET,PUT,OPTIONS"},
+		{"reflect request wins over AdvertiseOptions", AdvertiseReflectRequest, true, []string{"GET", "PUT"}, "PUT"},
+		{"unknown", 7, false, []string{"GET", "PUT"}, "GET,PUT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "PUT")
+
+			Filter(Config{
+				AllowedOrigins:      "http://foobar.com",
+				AllowedMethods:      "GET,PUT,OPTIONS",
+				MethodAdvertisement: tt.advertisement,
+				AdvertiseOptions:    tt.options,
+				MethodsProvider: func(r *http.Request) []string {
+					return tt.provider
+				},
+				Logger: log.New(new(bytes.Buffer), "", 0),
+			})(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, http.StatusOK)
+			if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != tt.methods {
+				t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `%s', got `%s'", tt.methods, actual)
+			}
+		})
+	}
+}
+
+func TestForwardRequestPaths(t *testing.T) {
+	var tests = []struct {
+		name    string
+		forward bool
+		path    string
+		code    int
+		body    string
+	}{
+		{"forwarded", false, "/custom", http.StatusOK, "custom options"},
+		{"forwarded sub path", false, "/custom/1", http.StatusOK, "custom options"},
+		{"not forwarded", false, "/other", http.StatusOK, ""},
+		{"not forwarded, ForwardRequest", true, "/other", http.StatusOK, ""},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" && strings.HasPrefix(r.URL.Path, "/custom") {
+			w.Write([]byte("custom options"))
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:      "http://foobar.com",
+				ForwardRequest:      tt.forward,
+				ForwardRequestPaths: []string{"/custom"},
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", "http://example.com"+tt.path, nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			req.Header.Add("Access-Control-Request-Method", "GET")
+
+			f(handler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			assertHeaders(t, res.Header(), map[string]string{
+				"Access-Control-Allow-Origin":  "http://foobar.com",
+				"Access-Control-Allow-Methods": DefaultAllowedMethods,
+			})
+			if body := res.Body.String(); body != tt.body {
+				t.Errorf("Invalid body, wanted `%s', got `%s'", tt.body, body)
+			}
+		})
+	}
+}
+
+func TestRejectSchemeDowngrade(t *testing.T) {
+	type step struct {
+		origin string
+		code   int
+	}
+	var tests = []struct {
+		name    string
+		origins string
+		steps   []step
+	}{
+		{"static origins", "https://foobar.com,http://foobar.com,http://bar.com", []step{
+			{"http://bar.com", http.StatusOK},
+			{"https://foobar.com", http.StatusOK},
+			{"http://foobar.com", http.StatusForbidden},
+			{"https://foobar.com", http.StatusOK},
+			{"http://bar.com", http.StatusOK},
+			// a disallowed origin isn't tracked
+			{"https://other.com", http.StatusForbidden},
+		}},
+		{"all origins", "*", []step{
+			{"https://victim.com", http.StatusOK},
+			{"http://victim.com", http.StatusOK},
+		}},
+		{"patterns", "*://*.example.com", []step{
+			{"https://victim.example.com", http.StatusOK},
+			{"http://victim.example.com", http.StatusOK},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filter(Config{
+				AllowedOrigins:        tt.origins,
+				RejectSchemeDowngrade: true,
+				Logger:                log.New(new(bytes.Buffer), "", 0),
+			})
+
+			for _, s := range tt.steps {
+				res := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Add("Origin", s.origin)
+
+				f(testHandler).ServeHTTP(res, req)
+
+				if res.Code != s.code {
+					t.Errorf("Invalid response code of %s, wanted %d, got %d", s.origin, s.code, res.Code)
+				}
+				if s.code == http.StatusForbidden {
+					assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+				}
+			}
+		})
+	}
+}
+
+// hijackRecorder a ResponseRecorder that implements http.Hijacker
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestWrappedWriterHijack(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config Config
+	}{
+		{"canonical vary", Config{AllowedOrigins: "http://foobar.com", CanonicalVary: true}},
+		{"private cache on reflect", Config{AllowedOrigins: "http://foobar.com,http://barbaz.com", PrivateCacheOnReflect: true}},
+		{"suppress headers on server error", Config{AllowedOrigins: "http://foobar.com", SuppressHeadersOnServerError: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unwrapped http.ResponseWriter
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+					unwrapped = u.Unwrap()
+				}
+				h, ok := w.(http.Hijacker)
+				if !ok {
+					t.Fatalf("the ResponseWriter isn't an http.Hijacker")
+				}
+				if _, _, err := h.Hijack(); err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+			})
+
+			res := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", "http://foobar.com")
+
+			Filter(tt.config)(handler).ServeHTTP(res, req)
+
+			if !res.hijacked {
+				t.Errorf("the connection wasn't hijacked")
+			}
+			if unwrapped == nil {
+				t.Errorf("the ResponseWriter can't be unwrapped")
+			}
+		})
+	}
+}
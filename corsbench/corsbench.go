@@ -0,0 +1,84 @@
+// Package corsbench helpers to measure the per request cost of a cors.Config, e.g. for capacity planning:
+//
+//	func BenchmarkPolicy(b *testing.B) {
+//		corsbench.Benchmark(b, myConfig, corsbench.Actual("GET", "https://app.example.com"), corsbench.Preflight("https://app.example.com", "PUT"))
+//	}
+package corsbench
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vpxyz/cors"
+)
+
+// Target the URL of the requests built by Actual and Preflight
+const Target = "http://example.com/foo"
+
+// discardResponse a ResponseWriter that throws away the response
+type discardResponse struct {
+	header http.Header
+}
+
+func (r *discardResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *discardResponse) WriteHeader(n int) {
+}
+
+func (r *discardResponse) Write(b []byte) (n int, err error) {
+	return len(b), nil
+}
+
+// reset drop the headers written by the last request, keeping the map
+func (r *discardResponse) reset() {
+	for k := range r.header {
+		delete(r.header, k)
+	}
+}
+
+// noop the handler wrapped by the filter, it writes nothing so only the filter is measured
+var noop = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// Benchmark measure the filter built from config with cors.Filter, the real request path.
+// Each iteration serves all the requests in turn, so b reports the cost of the whole set.
+// The requests are reused, so config must not change them, e.g. with StripOriginOnForward
+func Benchmark(b *testing.B, config cors.Config, requests ...*http.Request) {
+	if len(requests) == 0 {
+		b.Fatal("corsbench: no requests to serve")
+	}
+
+	h := cors.Filter(config)(noop)
+	w := &discardResponse{header: http.Header{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range requests {
+			w.reset()
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Actual return an actual CORS request from origin, with the given method
+func Actual(method, origin string) *http.Request {
+	r, _ := http.NewRequest(method, Target, nil)
+	r.Header.Set(cors.OriginHeader, origin)
+
+	return r
+}
+
+// Preflight return a preflight request from origin for method, and the optional request headers
+func Preflight(origin, method string, headers ...string) *http.Request {
+	r, _ := http.NewRequest(http.MethodOptions, Target, nil)
+	r.Header.Set(cors.OriginHeader, origin)
+	r.Header.Set(cors.AccessControlRequestMethod, method)
+	if len(headers) > 0 {
+		r.Header.Set(cors.AccessControlRequestHeaders, strings.Join(headers, ", "))
+	}
+
+	return r
+}
@@ -0,0 +1,78 @@
+package corsbench
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vpxyz/cors"
+)
+
+// largeOrigins a policy with many static origins and some patterns
+func largeOrigins(n int) string {
+	origins := make([]string, 0, n+2)
+	for i := 0; i < n; i++ {
+		origins = append(origins, fmt.Sprintf("https://app%d.example.com", i))
+	}
+	origins = append(origins, "https://*.partner.com", ".example.org")
+
+	return strings.Join(origins, ",")
+}
+
+func BenchmarkLargeOriginList(b *testing.B) {
+	config := cors.Config{
+		AllowedOrigins:   largeOrigins(1000),
+		AllowedMethods:   cors.DefaultAllowedMethods + "," + http.MethodPut,
+		AllowedHeaders:   "X-Header-1, X-Header-2",
+		AllowCredentials: true,
+	}
+
+	b.Run("static", func(b *testing.B) {
+		Benchmark(b, config, Actual("GET", "https://app999.example.com"))
+	})
+	b.Run("pattern", func(b *testing.B) {
+		Benchmark(b, config, Actual("GET", "https://api.partner.com"))
+	})
+	b.Run("rejected", func(b *testing.B) {
+		Benchmark(b, config, Actual("GET", "https://evil.com"))
+	})
+	b.Run("mixed", func(b *testing.B) {
+		Benchmark(b, config,
+			Actual("GET", "https://app1.example.com"),
+			Preflight("https://app1.example.com", "PUT", "X-Header-1"),
+			Actual("POST", "https://www.example.org"))
+	})
+}
+
+func TestBenchmark(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the benchmark runs for about a second")
+	}
+
+	res := testing.Benchmark(func(b *testing.B) {
+		Benchmark(b, cors.Config{AllowedOrigins: largeOrigins(10)}, Actual("GET", "https://app1.example.com"))
+	})
+
+	if res.N == 0 {
+		t.Errorf("the benchmark didn't run")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	r := Preflight("https://app1.example.com", "PUT", "X-Header-1", "X-Header-2")
+	if r.Method != http.MethodOptions {
+		t.Errorf("Invalid method, wanted `OPTIONS', got `%s'", r.Method)
+	}
+	if actual := r.Header.Get("Access-Control-Request-Method"); actual != "PUT" {
+		t.Errorf("Invalid header `Access-Control-Request-Method', wanted `PUT', got `%s'", actual)
+	}
+	if actual := r.Header.Get("Access-Control-Request-Headers"); actual != "X-Header-1, X-Header-2" {
+		t.Errorf("Invalid header `Access-Control-Request-Headers', wanted `X-Header-1, X-Header-2', got `%s'", actual)
+	}
+
+	r = Actual("POST", "https://app1.example.com")
+	if r.Method != http.MethodPost || r.Header.Get("Origin") != "https://app1.example.com" {
+		t.Errorf("Invalid request %s with Origin `%s'", r.Method, r.Header.Get("Origin"))
+	}
+}
@@ -0,0 +1,158 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// decision the outcome of the checks of a cors request, computed once by decide.
+// The filter, the tracer and the metrics hook all read it
+type decision struct {
+	match         originMatch // how the origin matched
+	preflight     bool        // it's a preflight request
+	bareOptions   bool        // it's an OPTIONS request without Access-Control-Request-Method, answered with OptionsAlways200
+	originAllowed bool
+	reported      bool // the origin isn't allowed, but it's served in report only mode
+	// not a cross origin request by its fetch metadata, the checks apply but no CORS header is added
+	fetchSameOrigin bool
+	methodAllowed   bool // the request method, and the requested method of a preflight request
+	headersAllowed  bool // the requested headers of a preflight request
+
+	// status the rejection status code, 0 if the request is allowed
+	status int
+	// preflightRejected true if the preflight validation rejected the request, i.e. the origin and the request method are allowed
+	preflightRejected bool
+	// reason the decision, traced as cors.decision, e.g. "allowed" or "origin_not_allowed"
+	reason string
+	// message the log message of a rejection
+	message string
+
+	requestMethod  string // Access-Control-Request-Method of a preflight request
+	requestHeaders string // Access-Control-Request-Headers of a preflight request
+	allowedMethods string // the methods to advertise in Access-Control-Allow-Methods, or in the Allow header of a 405 response
+	allowedHeaders string // the headers to advertise in Access-Control-Allow-Headers
+	reflectHeaders bool   // advertise the requested headers, all headers are allowed
+}
+
+// reject set the rejection status code, reason and log message
+func (d *decision) reject(status int, reason, format string, v ...interface{}) decision {
+	d.status = status
+	d.reason = reason
+	d.message = fmt.Sprintf(format, v...)
+
+	return *d
+}
+
+// decide run all the checks of the cors request r, from origin, stopping at the first rejection
+func (c *cors) decide(r *http.Request, origin string, parsed parsedOrigin) decision {
+	d := decision{preflight: r.Method == http.MethodOptions}
+
+	// fail fast, don't match an oversized origin
+	if len(origin) > c.maxOriginLength {
+		return d.reject(http.StatusForbidden, "origin_too_long", "Origin from %s too long, %d bytes exceed MaxOriginLength = %d", remoteAddr(r), len(origin), c.maxOriginLength)
+	}
+
+	d.match = c.matchOrigin(parsed, r)
+	d.fetchSameOrigin = c.fetchSameOrigin(r)
+	if d.match == matchNone && d.fetchSameOrigin && parsed.sameOrigin(parseOrigin(serverOrigin(r))) {
+		d.match = matchSelf
+	}
+	if d.match == matchNone && !c.reportOnly {
+		return d.reject(http.StatusForbidden, "origin_not_allowed", "Origin %+v from %s not allowed", origin, remoteAddr(r))
+	}
+	d.originAllowed = d.match != matchNone
+	d.reported = !d.originAllowed
+
+	// only the explicitly listed origins are tracked, never the reflected ones, the Origin header is set by the client
+	if c.downgrades != nil && d.originAllowed && c.downgrades.downgraded(parsed, d.match == matchStatic) {
+		d.originAllowed = false
+		return d.reject(http.StatusForbidden, "scheme_downgrade", "Origin %+v from %s rejected, its host was seen over https", origin, remoteAddr(r))
+	}
+
+	if !c.isMethodAllowed(r.Method) {
+		d.allowedMethods = c.resourceMethods(r)
+		return d.reject(http.StatusMethodNotAllowed, "method_not_allowed", "Request method %+v from %s not allowed", r.Method, remoteAddr(r))
+	}
+
+	if c.requireCORSFetchMode && isStateChangingMethod(r.Method) && r.Header.Get(SecFetchModeHeader) != "cors" && !parsed.sameOrigin(parseOrigin(serverOrigin(r))) {
+		return d.reject(http.StatusForbidden, "fetch_mode_not_cors", "Request method %+v from %s without %s: cors", r.Method, remoteAddr(r), SecFetchModeHeader)
+	}
+
+	// the preflight requests can't carry the token
+	if c.verifyOriginToken != nil && !d.preflight && !c.verifyOriginToken(r.Header.Get(c.originTokenHeader), origin) {
+		return d.reject(http.StatusForbidden, "invalid_origin_token", "Origin %+v from %s with an invalid %s", origin, remoteAddr(r), c.originTokenHeader)
+	}
+
+	if !d.preflight {
+		d.methodAllowed, d.headersAllowed = true, true
+		d.reason = "allowed"
+		return d
+	}
+
+	d.requestMethod = r.Header.Get(AccessControlRequestMethod)
+
+	// a bare OPTIONS request, without Access-Control-Request-Method, isn't a real preflight
+	if d.requestMethod == "" && c.optionsAlways200 {
+		d.bareOptions = true
+		d.methodAllowed, d.headersAllowed = true, true
+		d.reason = "allowed"
+		return d
+	}
+
+	// from here the rejections are preflight validation failures
+	d.preflightRejected = true
+
+	if len(d.requestMethod) > c.maxRequestMethodLen {
+		return d.reject(http.StatusBadRequest, "request_method_too_long", "Preflight request not valid, requested method is longer than %d bytes", c.maxRequestMethodLen)
+	}
+
+	// browsers always upper case the requested method
+	if c.strictMethodCase && d.requestMethod != strings.ToUpper(d.requestMethod) {
+		return d.reject(http.StatusBadRequest, "request_method_not_upper_case", "Preflight request not valid, requested method %s isn't upper case", d.requestMethod)
+	}
+
+	d.methodAllowed, d.allowedMethods = c.preflightMethods(r, d.requestMethod)
+	if !d.methodAllowed {
+		return d.reject(c.preflightMethodRejectStatus, "request_method_not_allowed", "Preflight request not valid, requested method %s non allowed", d.requestMethod)
+	}
+
+	d.requestHeaders = r.Header.Get(AccessControlRequestHeaders)
+
+	// the headers allowed for the requested method, or the global ones
+	mh, byMethod := c.allowedHeadersByMethod[d.requestMethod]
+	if byMethod {
+		d.allowedHeaders = mh.list
+	} else {
+		d.allowedHeaders = c.allowedHeadersString
+		d.reflectHeaders = c.allowAllHeaders
+	}
+
+	if c.rejectSimpleMethodPreflight && isSimpleMethod(d.requestMethod) && !hasNonSimpleHeaders(d.requestHeaders) {
+		return d.reject(http.StatusBadRequest, "simple_method_preflight", "Preflight request not valid, requested method %s is simple and no non simple headers are requested", d.requestMethod)
+	}
+
+	if c.strictRequestHeaders && hasForbiddenHeaders(d.requestHeaders) {
+		return d.reject(http.StatusBadRequest, "forbidden_request_headers", "Preflight request not valid, request headers contain a forbidden header name")
+	}
+
+	// a client can send the wildcard too, it's reflected only if all headers are allowed
+	if !d.reflectHeaders && hasWildcardHeader(d.requestHeaders) {
+		return d.reject(http.StatusForbidden, "wildcard_request_headers", "Preflight request not valid, request headers contain the wildcard *, allowed only with AllowedHeaders = *")
+	}
+
+	if byMethod && !headersAllowed(mh.allowed, d.requestHeaders) || !byMethod && !c.areReqHeadersAllowed(d.requestHeaders) {
+		return d.reject(c.preflightHeaderRejectStatus, "request_headers_not_allowed", "Preflight request not valid, request headers not allowed")
+	}
+
+	// the reflected headers can't be truncated, the browser would reject the actual request anyway
+	if d.reflectHeaders && c.maxResponseHeaderBytes > 0 && len(trimHeaders(d.requestHeaders)) > c.maxResponseHeaderBytes {
+		return d.reject(c.preflightHeaderRejectStatus, "request_headers_too_long", "Preflight request not valid, request headers exceed MaxResponseHeaderBytes = %d", c.maxResponseHeaderBytes)
+	}
+
+	d.headersAllowed = true
+	d.preflightRejected = false
+	d.reason = "preflight_allowed"
+
+	return d
+}
@@ -0,0 +1,60 @@
+package cors
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	c := initialize(Config{
+		AllowedOrigins: "http://foobar.com, http://*.bar.com",
+		AllowedHeaders: "X-Header-1",
+	})
+
+	var tests = []struct {
+		name      string
+		method    string
+		origin    string
+		reqMethod string
+		reqHeader string
+		want      decision
+	}{
+		{"actual request", "GET", "http://foobar.com", "", "",
+			decision{match: matchStatic, originAllowed: true, methodAllowed: true, headersAllowed: true, reason: "allowed"}},
+		{"origin not allowed", "GET", "http://foo.com", "", "",
+			decision{status: http.StatusForbidden, reason: "origin_not_allowed"}},
+		{"method not allowed", "PUT", "http://foobar.com", "", "",
+			decision{match: matchStatic, originAllowed: true, status: http.StatusMethodNotAllowed, reason: "method_not_allowed", allowedMethods: DefaultAllowedMethods}},
+		{"preflight", "OPTIONS", "http://app.bar.com", "POST", "X-Header-1",
+			decision{match: matchPattern, preflight: true, originAllowed: true, methodAllowed: true, headersAllowed: true, reason: "preflight_allowed",
+				requestMethod: "POST", requestHeaders: "X-Header-1", allowedMethods: DefaultAllowedMethods, allowedHeaders: "X-Header-1"}},
+		{"preflight method not allowed", "OPTIONS", "http://foobar.com", "PUT", "",
+			decision{match: matchStatic, preflight: true, originAllowed: true, status: http.StatusMethodNotAllowed, preflightRejected: true, reason: "request_method_not_allowed",
+				requestMethod: "PUT", allowedMethods: DefaultAllowedMethods}},
+		{"preflight headers not allowed", "OPTIONS", "http://foobar.com", "GET", "X-Header-2",
+			decision{match: matchStatic, preflight: true, originAllowed: true, methodAllowed: true, status: http.StatusForbidden, preflightRejected: true, reason: "request_headers_not_allowed",
+				requestMethod: "GET", requestHeaders: "X-Header-2", allowedMethods: DefaultAllowedMethods, allowedHeaders: "X-Header-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			if tt.reqMethod != "" {
+				req.Header.Add("Access-Control-Request-Method", tt.reqMethod)
+			}
+			if tt.reqHeader != "" {
+				req.Header.Add("Access-Control-Request-Headers", tt.reqHeader)
+			}
+
+			d := c.decide(req, tt.origin, parseOrigin(tt.origin))
+			if (d.message == "") != (tt.want.status == 0) {
+				t.Errorf("got the message %q with status %d", d.message, d.status)
+			}
+			d.message = ""
+			if d != tt.want {
+				t.Errorf("got %+v, want %+v", d, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cors
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxTrackedHTTPSHosts maximum number of hosts seen over https tracked to detect the scheme downgrades
+const maxTrackedHTTPSHosts = 4096
+
+// downgradeTracker track the hosts of the static allowed origins seen over https, to reject the same hosts over http.
+// The state is per process and bounded, the oldest host is forgotten first, so the detection is best-effort
+type downgradeTracker struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+	order []string // the tracked hosts, oldest first
+}
+
+// newDowngradeTracker return an empty tracker
+func newDowngradeTracker() *downgradeTracker {
+	return &downgradeTracker{hosts: make(map[string]bool)}
+}
+
+// downgraded return true if the origin is http and its host was seen over https.
+// If record is true, the host of an https origin is tracked
+func (t *downgradeTracker) downgraded(p parsedOrigin, record bool) bool {
+	host := strings.ToLower(p.host)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch p.scheme {
+	case "https":
+		if !record || t.hosts[host] {
+			return false
+		}
+		if len(t.order) >= maxTrackedHTTPSHosts {
+			// keep the state bounded, evict the oldest host
+			delete(t.hosts, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.hosts[host] = true
+		t.order = append(t.order, host)
+	case "http":
+		return t.hosts[host]
+	}
+
+	return false
+}
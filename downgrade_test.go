@@ -0,0 +1,53 @@
+package cors
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDowngradeTracker(t *testing.T) {
+	tr := newDowngradeTracker()
+
+	var steps = []struct {
+		origin     string
+		downgraded bool
+	}{
+		{"http://foobar.com", false},
+		{"https://foobar.com", false},
+		{"http://foobar.com", true},
+		{"http://FOOBAR.com:8080", true},
+		{"https://foobar.com", false},
+		{"http://bar.com", false},
+	}
+
+	for _, s := range steps {
+		if d := tr.downgraded(parseOrigin(s.origin), true); d != s.downgraded {
+			t.Errorf("downgraded(%s) got %v, want %v", s.origin, d, s.downgraded)
+		}
+	}
+
+	// not recorded
+	tr.downgraded(parseOrigin("https://bar.com"), false)
+	if tr.downgraded(parseOrigin("http://bar.com"), true) {
+		t.Errorf("bar.com tracked without record")
+	}
+
+	// the state is bounded, the oldest hosts are evicted one by one
+	for i := 0; i < maxTrackedHTTPSHosts-1; i++ {
+		tr.downgraded(parseOrigin(fmt.Sprintf("https://%d.example.com", i)), true)
+	}
+	if len(tr.hosts) != maxTrackedHTTPSHosts || len(tr.order) != maxTrackedHTTPSHosts {
+		t.Errorf("tracked %d hosts, want %d", len(tr.hosts), maxTrackedHTTPSHosts)
+	}
+	if !tr.downgraded(parseOrigin("http://foobar.com"), true) {
+		t.Errorf("foobar.com evicted before the table is full")
+	}
+
+	tr.downgraded(parseOrigin("https://new.example.com"), true)
+	if tr.downgraded(parseOrigin("http://foobar.com"), true) {
+		t.Errorf("the oldest host foobar.com not evicted")
+	}
+	if !tr.downgraded(parseOrigin("http://0.example.com"), true) || !tr.downgraded(parseOrigin("http://new.example.com"), true) {
+		t.Errorf("the newer hosts evicted")
+	}
+}
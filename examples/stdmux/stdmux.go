@@ -12,14 +12,15 @@ func main() {
 	logger := log.New(os.Stdout, "CORS: ", log.LstdFlags)
 
 	corsMiddleware := cors.Filter(cors.Config{
-		AllowedOrigins:   "http://foobar.com, http://*.example.com",                        // origins
-		AllowedMethods:   cors.DefaultAllowedMethods + "," + http.MethodPut,                // put here your allowed methods
-		AllowedHeaders:   cors.DefaultAllowedHeaders + ",X-Custom-Header,X-Requested-With", // some allowed headers
-		MaxAge:           3000,                                                             // indicates how long the results of a preflight request can be cached (default 1800)
-		ExposedHeaders:   "X-Custom-Header",                                                // exposer headers
-		AllowCredentials: true,                                                             // indicates that request whether include credentials
-		ForwardRequest:   true,                                                             // if true, preflight request are forwarded to handler (dafault false)
-		Logger:           logger,                                                           // optional logger
+		AllowedOrigins:   "http://foobar.com, http://*.example.com",         // origins
+		AllowedMethods:   cors.DefaultAllowedMethods + "," + http.MethodPut, // put here your allowed methods
+		AllowedHeaders:   cors.DefaultAllowedHeaders + ",X-Custom-Header",   // some allowed headers
+		LegacyAJAXCompat: true,                                              // allow X-Requested-With
+		MaxAge:           3000,                                              // indicates how long the results of a preflight request can be cached (default 1800)
+		ExposedHeaders:   "X-Custom-Header",                                 // exposer headers
+		AllowCredentials: true,                                              // indicates that request whether include credentials
+		ForwardRequest:   true,                                              // if true, preflight request are forwarded to handler (dafault false)
+		Logger:           logger,                                            // optional logger
 	})
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,49 @@
+package cors
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// FilterByHost cors filter middleware that applies a different Config for each host, selected by the request Host header.
+// A key may contain the port (e.g. "example.com:8080"), otherwise it matches any port.
+// The key "*" is the fallback Config for the other hosts, without it the requests to the other hosts are forwarded untouched.
+// Each Config is compiled once
+func FilterByHost(configs map[string]Config) (fn func(next http.Handler) http.Handler) {
+	filters := make(map[string]*cors, len(configs))
+	for host, config := range configs {
+		filters[strings.ToLower(host)] = initialize(config)
+	}
+
+	fn = func(next http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(filters))
+		for host, c := range filters {
+			handlers[host] = c.handler(next)
+		}
+
+		filter := func(w http.ResponseWriter, r *http.Request) {
+			host := strings.ToLower(r.Host)
+
+			h, ok := handlers[host]
+			if !ok {
+				if hostname, _, err := net.SplitHostPort(host); err == nil {
+					h, ok = handlers[hostname]
+				}
+			}
+			if !ok {
+				h, ok = handlers[OriginMatchAll]
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(filter)
+	}
+
+	return fn
+}
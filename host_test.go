@@ -0,0 +1,61 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterByHost(t *testing.T) {
+	f := FilterByHost(map[string]Config{
+		"a.com":      {AllowedOrigins: "http://foo.com"},
+		"b.com":      {AllowedOrigins: "http://bar.com"},
+		"b.com:8080": {AllowedOrigins: "http://baz.com"},
+		"*":          {AllowedOrigins: "http://qux.com"},
+	})
+
+	var tests = []struct {
+		name   string
+		url    string
+		origin string
+		code   int
+	}{
+		{"a.com allowed", "http://a.com/foo", "http://foo.com", http.StatusOK},
+		{"a.com disallowed", "http://a.com/foo", "http://bar.com", http.StatusForbidden},
+		{"b.com allowed", "http://b.com/foo", "http://bar.com", http.StatusOK},
+		{"b.com disallowed", "http://b.com/foo", "http://foo.com", http.StatusForbidden},
+		{"A.com any port", "http://A.com:3000/foo", "http://foo.com", http.StatusOK},
+		{"b.com port", "http://b.com:8080/foo", "http://baz.com", http.StatusOK},
+		{"b.com port disallowed", "http://b.com:8080/foo", "http://bar.com", http.StatusForbidden},
+		{"fallback allowed", "http://c.com/foo", "http://qux.com", http.StatusOK},
+		{"fallback disallowed", "http://c.com/foo", "http://foo.com", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestFilterByHostWithoutFallback(t *testing.T) {
+	f := FilterByHost(map[string]Config{
+		"a.com": {AllowedOrigins: "http://foo.com"},
+	})
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://b.com/foo", nil)
+	req.Header.Add("Origin", "http://bar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	// forwarded untouched
+	assertNoHeaders(t, res.Header(), "Vary", "Access-Control-Allow-Origin")
+	assertResponse(t, res, http.StatusOK)
+}
@@ -0,0 +1,143 @@
+package cors
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// punycode parameters, see RFC 3492
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+
+	// acePrefix the prefix of the punycode encoded labels
+	acePrefix = "xn--"
+)
+
+// isASCII return true if s has only ASCII chars
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// toASCII convert each internationalized label of the host to its punycode form, e.g. "münchen.de" to "xn--mnchen-3ya.de".
+// The labels are only lower cased, the full IDNA mapping isn't applied. A port, if any, is left untouched
+func toASCII(host string) string {
+	if isASCII(host) {
+		return host
+	}
+
+	labels := strings.Split(host, ".")
+	for i, l := range labels {
+		if !isASCII(l) {
+			labels[i] = acePrefix + punycode(strings.ToLower(l))
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// toASCIIOrigin like toASCII, but for a whole origin, e.g. "https://shop.münchen.de"
+func toASCIIOrigin(origin string) string {
+	if isASCII(origin) {
+		return origin
+	}
+
+	if i := strings.Index(origin, "://"); i >= 0 {
+		return origin[:i+len("://")] + toASCII(origin[i+len("://"):])
+	}
+	return toASCII(origin)
+}
+
+// punycode encode the label with the punycode algorithm, without the ACE prefix
+func punycode(label string) string {
+	runes := []rune(label)
+
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+	for h := basic; h < len(runes); {
+		// the next code point to encode
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+
+	return string(out)
+}
+
+// punycodeDigit the char of the digit d, 0-25 are 'a'-'z', 26-35 are '0'-'9'
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punycodeAdapt the bias adaptation function
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
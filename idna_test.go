@@ -0,0 +1,27 @@
+package cors
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	var tests = []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"shop.MÜNCHEN.de:8080", "shop.xn--mnchen-3ya.de:8080"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"例え.jp", "xn--r8jz45g.jp"},
+		{"пример.рф", "xn--e1afmkfd.xn--p1ai"},
+	}
+
+	for _, tt := range tests {
+		if actual := toASCII(tt.host); actual != tt.want {
+			t.Errorf("Invalid punycode of %s, wanted `%s', got `%s'", tt.host, tt.want, actual)
+		}
+	}
+
+	if actual := toASCIIOrigin("https://shop.münchen.de"); actual != "https://shop.xn--mnchen-3ya.de" {
+		t.Errorf("Invalid punycode origin, got `%s'", actual)
+	}
+}
@@ -0,0 +1,72 @@
+package cors
+
+import "strings"
+
+// Normalized return a copy of the config with the defaults applied and the list fields trimmed and deduplicated,
+// i.e. the values the filter will use. The methods are upper cased and the schemes lower cased,
+// headers are deduplicated case-insensitively keeping the first casing
+func (config Config) Normalized() Config {
+	n := config
+
+	if len(n.AllowedOrigins) == 0 && n.AllowOriginFunc == nil && len(n.OriginMatchers) == 0 && n.OriginsLoader == nil {
+		n.AllowedOrigins = OriginMatchAll
+	}
+	n.AllowedOrigins = normalizeList(n.AllowedOrigins, nil)
+
+	if len(n.AllowedMethods) == 0 {
+		n.AllowedMethods = DefaultAllowedMethods
+	}
+	n.AllowedMethods = normalizeList(strings.ToUpper(n.AllowedMethods), nil)
+
+	switch h := strings.TrimSpace(n.AllowedHeaders); {
+	case h == "":
+		n.AllowedHeaders = DefaultAllowedHeaders
+	case h == "*" || h == NoAllowedHeaders:
+		n.AllowedHeaders = h
+	default:
+		n.AllowedHeaders = normalizeList(n.AllowedHeaders, strings.ToLower)
+	}
+
+	n.ExposedHeaders = normalizeList(n.ExposedHeaders, strings.ToLower)
+	n.AllowedSchemes = normalizeList(strings.ToLower(n.AllowedSchemes), nil)
+
+	if n.MaxAge <= 0 {
+		n.MaxAge = DefaultMaxAge
+	}
+
+	if n.MaxRequestMethodLen <= 0 {
+		n.MaxRequestMethodLen = DefaultMaxRequestMethodLen
+	}
+
+	if n.MaxOriginPatternSize <= 0 {
+		n.MaxOriginPatternSize = DefaultMaxOriginPatternSize
+	}
+
+	return n
+}
+
+// normalizeList trim the items of a comma separated list and drop the empty and duplicate ones.
+// key, if not nil, return the key used to detect the duplicates
+func normalizeList(list string, key func(string) string) string {
+	seen := make(map[string]bool)
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		k := item
+		if key != nil {
+			k = key(item)
+		}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		items = append(items, item)
+	}
+
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,57 @@
+package cors
+
+import "testing"
+
+func TestNormalized(t *testing.T) {
+	messy := Config{
+		AllowedOrigins: " http://foobar.com ,,http://bar.com, http://foobar.com ",
+		AllowedMethods: "get, POST,Get ,",
+		AllowedHeaders: " X-Header-1,x-header-1 , Content-Type,",
+		ExposedHeaders: "X-Header-2, X-HEADER-2",
+		AllowedSchemes: "HTTP, https,http",
+	}
+
+	n := messy.Normalized()
+
+	var tests = []struct {
+		field string
+		got   string
+		want  string
+	}{
+		{"AllowedOrigins", n.AllowedOrigins, "http://foobar.com,http://bar.com"},
+		{"AllowedMethods", n.AllowedMethods, "GET,POST"},
+		{"AllowedHeaders", n.AllowedHeaders, "X-Header-1,Content-Type"},
+		{"ExposedHeaders", n.ExposedHeaders, "X-Header-2"},
+		{"AllowedSchemes", n.AllowedSchemes, "http,https"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s got %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+
+	if n.MaxAge != DefaultMaxAge || n.MaxRequestMethodLen != DefaultMaxRequestMethodLen || n.MaxOriginPatternSize != DefaultMaxOriginPatternSize {
+		t.Errorf("the defaults aren't applied: %+v", n)
+	}
+
+	if messy.AllowedMethods != "get, POST,Get ," {
+		t.Errorf("the input config is modified")
+	}
+}
+
+func TestNormalizedDefaults(t *testing.T) {
+	n := Config{}.Normalized()
+
+	if n.AllowedOrigins != "*" || n.AllowedMethods != DefaultAllowedMethods || n.AllowedHeaders != DefaultAllowedHeaders {
+		t.Errorf("got %+v, want the defaults", n)
+	}
+
+	if n := (Config{AllowedHeaders: " - "}).Normalized(); n.AllowedHeaders != NoAllowedHeaders {
+		t.Errorf("got AllowedHeaders %q, want %q", n.AllowedHeaders, NoAllowedHeaders)
+	}
+
+	if n := (Config{AllowOriginFunc: func(string) bool { return true }}).Normalized(); n.AllowedOrigins != "" {
+		t.Errorf("got AllowedOrigins %q, want no origins with AllowOriginFunc", n.AllowedOrigins)
+	}
+}
@@ -0,0 +1,21 @@
+package cors
+
+import (
+	"net/http"
+	"strings"
+)
+
+// FirstPartyConfig return a config for the first party services living under apexDomain, e.g. "internal.company.com":
+// any subdomain is allowed over https, with credentials, the common methods and the Authorization header.
+// The apex domain itself isn't allowed, add it to AllowedOrigins if needed
+func FirstPartyConfig(apexDomain string) Config {
+	apexDomain = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(apexDomain), "*"), "."))
+
+	return Config{
+		AllowedOrigins:   "*://*." + apexDomain,
+		AllowedSchemes:   "https",
+		AllowedMethods:   DefaultAllowedMethods + "," + http.MethodPut + "," + http.MethodPatch + "," + http.MethodDelete,
+		AllowedHeaders:   DefaultAllowedHeaders + ",Authorization," + LegacyAJAXHeaders,
+		AllowCredentials: true,
+	}
+}
@@ -0,0 +1,63 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFirstPartyConfig(t *testing.T) {
+	var tests = []struct {
+		name        string
+		apex        string
+		origin      string
+		code        int
+		credentials string
+	}{
+		{"subdomain over https", "internal.company.com", "https://billing.internal.company.com", http.StatusOK, "true"},
+		{"nested subdomain", "internal.company.com", "https://api.eu.internal.company.com", http.StatusOK, "true"},
+		{"subdomain over http", "internal.company.com", "http://billing.internal.company.com", http.StatusForbidden, ""},
+		{"external origin", "internal.company.com", "https://evil.com", http.StatusForbidden, ""},
+		{"lookalike origin", "internal.company.com", "https://evilinternal.company.com", http.StatusForbidden, ""},
+		{"apex with dot", ".internal.company.com", "https://billing.internal.company.com", http.StatusOK, "true"},
+		{"apex with wildcard", "*.internal.company.com", "https://billing.internal.company.com", http.StatusOK, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FirstPartyConfig(tt.apex)
+			if err := ValidateConfig(config); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			Filter(config)(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if actual := res.Header().Get("Access-Control-Allow-Credentials"); actual != tt.credentials {
+				t.Errorf("Invalid header `Access-Control-Allow-Credentials', wanted `%s', got `%s'", tt.credentials, actual)
+			}
+		})
+	}
+}
+
+func TestFirstPartyConfigPreflight(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "https://billing.internal.company.com")
+	req.Header.Add("Access-Control-Request-Method", "DELETE")
+	req.Header.Add("Access-Control-Request-Headers", "Authorization, Content-Type")
+
+	Filter(FirstPartyConfig("internal.company.com"))(testHandler).ServeHTTP(res, req)
+
+	assertResponse(t, res, http.StatusOK)
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin":      "https://billing.internal.company.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "DELETE",
+		"Access-Control-Allow-Headers":     "Authorization",
+	})
+}
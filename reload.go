@@ -0,0 +1,52 @@
+package cors
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Reload reload the allowed origins with the OriginsLoader and rebuild the matchers.
+// If the loader fails, the current origins are kept. It's safe to call it concurrently with the requests
+func (c *Cors) Reload() {
+	if c.c.originsLoader == nil {
+		c.c.logWrap("Unable to reload the allowed origins, OriginsLoader isn't set")
+		return
+	}
+
+	c.c.loadOrigins()
+}
+
+// WatchSignal reload the allowed origins every time the process receives sig (e.g. syscall.SIGHUP), see Reload.
+// The returned function stops watching the signal and waits for the watcher to exit, the watch is stopped by Close too
+func (c *Cors) WatchSignal(sig os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig)
+
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(stopped)
+		})
+		<-exited
+	}
+
+	go func() {
+		defer close(exited)
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				c.Reload()
+			case <-stopped:
+				return
+			case <-c.c.done:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
@@ -0,0 +1,177 @@
+package cors
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	var mu sync.Mutex
+	list := []string{"http://foobar.com"}
+
+	c := New(Config{
+		OriginsLoader: func() ([]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return list, nil
+		},
+	})
+	h := c.Handler(testHandler)
+
+	request := func(origin string) int {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Add("Origin", origin)
+		h.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	if code := request("http://barbaz.com"); code != http.StatusForbidden {
+		t.Errorf("got %d before the reload, want %d", code, http.StatusForbidden)
+	}
+
+	mu.Lock()
+	list = []string{"http://barbaz.com"}
+	mu.Unlock()
+
+	// simulate the signal
+	c.Reload()
+
+	if code := request("http://barbaz.com"); code != http.StatusOK {
+		t.Errorf("got %d after the reload, want %d", code, http.StatusOK)
+	}
+	if code := request("http://foobar.com"); code != http.StatusForbidden {
+		t.Errorf("got %d for the removed origin, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestReloadWithoutLoader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := New(Config{
+		AllowedOrigins: "http://foobar.com",
+		Logger:         log.New(buf, "", 0),
+	})
+
+	c.Reload()
+
+	if !strings.Contains(buf.String(), "OriginsLoader isn't set") {
+		t.Errorf("missing log message, got %q", buf.String())
+	}
+	if !c.c.isOriginAllowed("http://foobar.com", nil) {
+		t.Errorf("the origins changed without a loader")
+	}
+}
+
+func TestOriginsRefresh(t *testing.T) {
+	var tests = []struct {
+		name    string
+		context bool
+	}{
+		{"close", false},
+		{"context", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loads := make(chan bool, 100)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			config := Config{
+				OriginsLoader: func() ([]string, error) {
+					loads <- true
+					return []string{"http://foobar.com"}, nil
+				},
+				OriginsRefreshInterval: 5 * time.Millisecond,
+			}
+			if tt.context {
+				config.RefreshContext = ctx
+			}
+			c := New(config)
+
+			// the first load, at init, and two refreshes
+			for i := 0; i < 3; i++ {
+				select {
+				case <-loads:
+				case <-time.After(time.Second):
+					t.Fatalf("got %d loads, want 3", i)
+				}
+			}
+
+			if tt.context {
+				cancel()
+			} else {
+				c.Close()
+				c.Close()
+			}
+			// a refresh may be running
+			time.Sleep(20 * time.Millisecond)
+			for len(loads) > 0 {
+				<-loads
+			}
+
+			select {
+			case <-loads:
+				t.Errorf("the origins are still refreshed")
+			case <-time.After(30 * time.Millisecond):
+			}
+			if !c.c.isOriginAllowed("http://foobar.com", nil) {
+				t.Errorf("the loaded origins are lost")
+			}
+		})
+	}
+}
+
+func TestWatchSignal(t *testing.T) {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+
+	loads := make(chan struct{}, 4)
+	c := New(Config{
+		OriginsLoader: func() ([]string, error) {
+			loads <- struct{}{}
+			return []string{"http://foobar.com"}, nil
+		},
+	})
+	<-loads
+
+	stop := c.WatchSignal(os.Interrupt)
+	if err := p.Signal(os.Interrupt); err != nil {
+		stop()
+		t.Skipf("unable to send the signal: %v", err)
+	}
+
+	select {
+	case <-loads:
+	case <-time.After(time.Second):
+		t.Errorf("the origins weren't reloaded on signal")
+	}
+
+	stop()
+	// it's safe to call stop and Close after the watch is stopped
+	stop()
+	c.Close()
+
+	stop = c.WatchSignal(os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("the watch wasn't stopped by Close")
+	}
+}
@@ -0,0 +1,85 @@
+package cors
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// reportTimeout timeout of the violation reports delivery
+	reportTimeout = 5 * time.Second
+
+	// maxPendingReports maximum number of violation reports being sent, the others are dropped
+	maxPendingReports = 8
+
+	// maxReportResponse maximum number of bytes of the report endpoint response read to reuse the connection
+	maxReportResponse = 4096
+)
+
+// ViolationReport the report sent to ReportURI for a request that the policy would block
+type ViolationReport struct {
+	Type   string `json:"type"` // always "cors-violation"
+	Origin string `json:"origin"`
+	Method string `json:"method"`
+	URL    string `json:"url"`    // without user info, query and fragment, they can carry secrets
+	Reason string `json:"reason"` // e.g. "origin_not_allowed"
+}
+
+// reportURL return the URL of r without user info, query and fragment
+func reportURL(r *http.Request) string {
+	u := *r.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// report send in background the violation report of r to ReportURI, if set.
+// At most maxPendingReports are sent at the same time, the others are dropped and logged
+func (c *cors) report(r *http.Request, origin, reason string) {
+	if c.reportURI == "" {
+		return
+	}
+
+	select {
+	case c.reportSlots <- struct{}{}:
+	default:
+		c.logRequest(r, LogError, "Error: violation report dropped, %d reports are being sent", maxPendingReports)
+		return
+	}
+
+	body, err := json.Marshal(ViolationReport{
+		Type:   "cors-violation",
+		Origin: origin,
+		Method: r.Method,
+		URL:    reportURL(r),
+		Reason: reason,
+	})
+	if err != nil {
+		<-c.reportSlots
+		c.logRequest(r, LogError, "Error: violation report not encoded, %v", err)
+		return
+	}
+
+	// r may change once the handler returned, read the correlation id now
+	id := c.correlationID(r)
+	go func() {
+		defer func() { <-c.reportSlots }()
+
+		res, err := c.reportClient.Post(c.reportURI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			c.logCorrelated(id, LogError, "Error: violation report not sent, %v", err)
+			return
+		}
+		// drain the body, so the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxReportResponse))
+		res.Body.Close()
+	}()
+}
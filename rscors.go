@@ -0,0 +1,83 @@
+package cors
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RSCorsOptions the github.com/rs/cors options, to ease the migration to this filter
+type RSCorsOptions struct {
+	// AllowedOrigins list of allowed origins, may contain "*"
+	AllowedOrigins []string
+	// AllowOriginFunc custom function to validate the origin, if set AllowedOrigins is ignored
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods list of allowed methods (default GET, POST and HEAD)
+	AllowedMethods []string
+	// AllowedHeaders list of non simple headers the client is allowed to use, may contain "*"
+	AllowedHeaders []string
+	// ExposedHeaders headers safe to expose
+	ExposedHeaders []string
+	// MaxAge in seconds, indicates how long the results of a preflight request can be cached
+	MaxAge int
+	// AllowCredentials indicates whether the request can include user credentials
+	AllowCredentials bool
+	// OptionsPassthrough forward the preflight request to the handler
+	OptionsPassthrough bool
+}
+
+// rsCorsDefaultAllowedHeaders github.com/rs/cors default allowed headers
+const rsCorsDefaultAllowedHeaders = "Origin,Accept,Content-Type,X-Requested-With"
+
+// FromRSCors convert the github.com/rs/cors options to a Config.
+// Unlike github.com/rs/cors, the filter handles the preflight requests only if OPTIONS is an allowed method, so it's always added.
+// When MaxAge <= 0 the filter default is used. The wildcard origins, e.g. "http://*.example.com", match the whole origin, like in github.com/rs/cors
+func FromRSCors(opts RSCorsOptions) Config {
+	config := Config{
+		AllowedOrigins:   strings.Join(opts.AllowedOrigins, ","),
+		AllowedHeaders:   strings.Join(opts.AllowedHeaders, ","),
+		ExposedHeaders:   strings.Join(opts.ExposedHeaders, ","),
+		MaxAge:           opts.MaxAge,
+		AllowCredentials: opts.AllowCredentials,
+		ForwardRequest:   opts.OptionsPassthrough,
+	}
+
+	if opts.AllowOriginFunc != nil {
+		config.AllowedOrigins = ""
+		config.AllowOriginFunc = opts.AllowOriginFunc
+	} else {
+		for _, o := range opts.AllowedOrigins {
+			if o == OriginMatchAll {
+				config.AllowedOrigins = OriginMatchAll
+				break
+			}
+		}
+	}
+
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodHead}
+	if len(opts.AllowedMethods) > 0 {
+		methods = make([]string, 0, len(opts.AllowedMethods)+1)
+		for _, m := range opts.AllowedMethods {
+			methods = append(methods, strings.ToUpper(m))
+		}
+	}
+	hasOptions := false
+	for _, m := range methods {
+		hasOptions = hasOptions || m == http.MethodOptions
+	}
+	if !hasOptions {
+		methods = append(methods, http.MethodOptions)
+	}
+	config.AllowedMethods = strings.Join(methods, ",")
+
+	if len(opts.AllowedHeaders) == 0 {
+		config.AllowedHeaders = rsCorsDefaultAllowedHeaders
+	}
+	for _, h := range opts.AllowedHeaders {
+		if h == "*" {
+			config.AllowedHeaders = "*"
+			break
+		}
+	}
+
+	return config
+}
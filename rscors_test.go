@@ -0,0 +1,164 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFromRSCors(t *testing.T) {
+	var tests = []struct {
+		name   string
+		in     RSCorsOptions
+		config Config
+	}{
+		{
+			"defaults",
+			RSCorsOptions{},
+			Config{
+				AllowedMethods: "GET,POST,HEAD,OPTIONS",
+				AllowedHeaders: "Origin,Accept,Content-Type,X-Requested-With",
+			},
+		},
+		{
+			"full",
+			RSCorsOptions{
+				AllowedOrigins:     []string{"http://foobar.com", "http://*.example.com"},
+				AllowedMethods:     []string{"get", "PUT", "OPTIONS"},
+				AllowedHeaders:     []string{"X-Header-1", "X-Header-2"},
+				ExposedHeaders:     []string{"X-Header-3"},
+				MaxAge:             10,
+				AllowCredentials:   true,
+				OptionsPassthrough: true,
+			},
+			Config{
+				AllowedOrigins:   "http://foobar.com,http://*.example.com",
+				AllowedMethods:   "GET,PUT,OPTIONS",
+				AllowedHeaders:   "X-Header-1,X-Header-2",
+				ExposedHeaders:   "X-Header-3",
+				MaxAge:           10,
+				AllowCredentials: true,
+				ForwardRequest:   true,
+			},
+		},
+		{
+			"wildcards",
+			RSCorsOptions{
+				AllowedOrigins: []string{"http://foobar.com", "*"},
+				AllowedHeaders: []string{"X-Header-1", "*"},
+			},
+			Config{
+				AllowedOrigins: "*",
+				AllowedMethods: "GET,POST,HEAD,OPTIONS",
+				AllowedHeaders: "*",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FromRSCors(tt.in)
+			if config.AllowedOrigins != tt.config.AllowedOrigins ||
+				config.AllowedMethods != tt.config.AllowedMethods ||
+				config.AllowedHeaders != tt.config.AllowedHeaders ||
+				config.ExposedHeaders != tt.config.ExposedHeaders ||
+				config.MaxAge != tt.config.MaxAge ||
+				config.AllowCredentials != tt.config.AllowCredentials ||
+				config.ForwardRequest != tt.config.ForwardRequest {
+				t.Errorf("got %+v, want %+v", config, tt.config)
+			}
+		})
+	}
+}
+
+func TestFromRSCorsPolicy(t *testing.T) {
+	f := Filter(FromRSCors(RSCorsOptions{
+		AllowOriginFunc: func(origin string) bool {
+			return strings.HasSuffix(origin, ".foobar.com")
+		},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"X-Header-1"},
+		ExposedHeaders:   []string{"X-Header-2"},
+		MaxAge:           10,
+		AllowCredentials: true,
+	}))
+
+	// preflight
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://api.foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "PUT")
+	req.Header.Add("Access-Control-Request-Headers", "X-Header-1")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Vary":                             "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+		"Access-Control-Allow-Origin":      "http://api.foobar.com",
+		"Access-Control-Allow-Methods":     "GET,PUT,OPTIONS",
+		"Access-Control-Allow-Headers":     "X-Header-1",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "10",
+	})
+	assertResponse(t, res, http.StatusOK)
+
+	// actual request
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://api.foobar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin":      "http://api.foobar.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Expose-Headers":    "X-Header-2",
+	})
+	assertResponse(t, res, http.StatusOK)
+
+	// the origin function rejects the origin
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://evil.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+	assertResponse(t, res, http.StatusForbidden)
+}
+
+func TestFromRSCorsWildcardOrigin(t *testing.T) {
+	var tests = []struct {
+		name   string
+		origin string
+		code   int
+	}{
+		{"subdomain", "http://a.example.com", http.StatusOK},
+		{"nested subdomain", "http://a.b.example.com", http.StatusOK},
+		{"other scheme", "https://a.example.com", http.StatusForbidden},
+		{"suffix of another domain", "http://a.example.com.evil.net", http.StatusForbidden},
+		{"origin in the query", "http://evil.net/?http://a.example.com", http.StatusForbidden},
+		{"origin in the path", "http://evil.net/a.example.com", http.StatusForbidden},
+		{"user info", "http://a.example.com@evil.net", http.StatusForbidden},
+	}
+
+	f := Filter(FromRSCors(RSCorsOptions{
+		AllowedOrigins: []string{"http://*.example.com"},
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+
+			f(testHandler).ServeHTTP(res, req)
+
+			assertResponse(t, res, tt.code)
+			if tt.code != http.StatusOK {
+				assertNoHeaders(t, res.Header(), "Access-Control-Allow-Origin")
+			}
+		})
+	}
+}
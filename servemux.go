@@ -0,0 +1,51 @@
+package cors
+
+import (
+	"net/http"
+	"strings"
+)
+
+// serveMuxMethods the methods probed on the ServeMux, if AllowedMethods is empty they are all allowed
+var serveMuxMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// FilterServeMux wrap mux with the cors filter, the preflight requests advertise only the methods routed by mux for the requested path.
+// With the Go 1.22 method patterns (e.g. "PUT /items/{id}") the methods are the registered ones, with the older patterns any method is routed.
+// The method patterns are supported only if the main module selects the Go 1.22 ServeMux (go >= 1.22 in its go.mod, or GODEBUG=httpmuxgo121=0),
+// otherwise every method is advertised
+// If AllowedMethods is empty, the GET, HEAD, POST, PUT, PATCH, DELETE and OPTIONS methods are allowed, the mux itself rejects the unrouted ones.
+// MethodsProvider is replaced
+func FilterServeMux(config Config, mux *http.ServeMux) http.Handler {
+	methods := serveMuxMethods
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = strings.Join(serveMuxMethods, ",") + "," + http.MethodOptions
+	} else {
+		methods = nil
+		for _, m := range strings.Split(config.AllowedMethods, ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" && m != http.MethodOptions {
+				methods = append(methods, m)
+			}
+		}
+	}
+
+	config.MethodsProvider = func(r *http.Request) []string {
+		return routedMethods(mux, r, methods)
+	}
+
+	return Filter(config)(mux)
+}
+
+// routedMethods return the methods, among candidates, that mux routes for the request path
+func routedMethods(mux *http.ServeMux, r *http.Request, candidates []string) []string {
+	var routed []string
+
+	probe := r.Clone(r.Context())
+	for _, m := range candidates {
+		probe.Method = m
+		// an empty pattern means not found, or method not allowed
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			routed = append(routed, m)
+		}
+	}
+
+	return routed
+}
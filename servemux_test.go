@@ -0,0 +1,98 @@
+//go:debug httpmuxgo121=0
+
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterServeMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("item"))
+	})
+	mux.HandleFunc("PUT /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("updated"))
+	})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("created"))
+	})
+
+	h := FilterServeMux(Config{AllowedOrigins: "http://foobar.com"}, mux)
+
+	var tests = []struct {
+		name      string
+		method    string
+		path      string
+		reqMethod string
+		code      int
+		methods   string
+	}{
+		{"item preflight", "OPTIONS", "/items/1", "PUT", http.StatusOK, "GET,HEAD,PUT"},
+		{"item preflight unrouted method", "OPTIONS", "/items/1", "POST", http.StatusMethodNotAllowed, ""},
+		{"items preflight", "OPTIONS", "/items", "POST", http.StatusOK, "POST"},
+		{"unknown path preflight", "OPTIONS", "/foo", "GET", http.StatusMethodNotAllowed, ""},
+		{"actual request", "PUT", "/items/1", "", http.StatusOK, ""},
+		{"actual request unrouted method", "DELETE", "/items/1", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			req.Header.Add("Origin", "http://foobar.com")
+			if tt.reqMethod != "" {
+				req.Header.Add("Access-Control-Request-Method", tt.reqMethod)
+			}
+
+			h.ServeHTTP(res, req)
+
+			if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != tt.methods {
+				t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `%s', got `%s'", tt.methods, actual)
+			}
+			assertResponse(t, res, tt.code)
+		})
+	}
+}
+
+func TestFilterServeMuxAllowedMethods(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("DELETE /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	h := FilterServeMux(Config{AllowedOrigins: "http://foobar.com", AllowedMethods: "GET,DELETE,OPTIONS"}, mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/items/1", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "DELETE")
+
+	h.ServeHTTP(res, req)
+
+	if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != "GET,DELETE" {
+		t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `GET,DELETE', got `%s'", actual)
+	}
+	assertResponse(t, res, http.StatusOK)
+}
+
+func TestFilterServeMuxPathPatterns(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {})
+
+	h := FilterServeMux(Config{AllowedOrigins: "http://foobar.com"}, mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/items/1", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+	req.Header.Add("Access-Control-Request-Method", "PUT")
+
+	h.ServeHTTP(res, req)
+
+	// without the method patterns, any method is routed
+	if actual := res.Header().Get("Access-Control-Allow-Methods"); actual != "GET,HEAD,POST,PUT,PATCH,DELETE" {
+		t.Errorf("Invalid header `Access-Control-Allow-Methods', wanted `GET,HEAD,POST,PUT,PATCH,DELETE', got `%s'", actual)
+	}
+	assertResponse(t, res, http.StatusOK)
+}
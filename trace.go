@@ -0,0 +1,50 @@
+package cors
+
+import "context"
+
+// Tracer optional hook to trace the CORS evaluation, e.g. with an adapter to an OpenTelemetry tracer.
+// It's a minimal interface, so the filter doesn't depend on any tracing library
+type Tracer interface {
+	// Start start a span named name, the returned context is passed to the next handler
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span a span started by a Tracer
+type Span interface {
+	// SetAttribute set a span attribute
+	SetAttribute(key, value string)
+	// End end the span
+	End()
+}
+
+// the span attributes
+const (
+	traceAttrOrigin   = "cors.origin"
+	traceAttrMatcher  = "cors.matcher"
+	traceAttrDecision = "cors.decision"
+)
+
+// String return the matcher type name
+func (m originMatch) String() string {
+	switch m {
+	case matchAll:
+		return "all"
+	case matchStatic:
+		return "static"
+	case matchSelf:
+		return "self"
+	case matchPattern:
+		return "pattern"
+	case matchFunc:
+		return "func"
+	}
+
+	return "none"
+}
+
+// traceDecision set the decision attribute, span can be nil
+func traceDecision(span Span, decision string) {
+	if span != nil {
+		span.SetAttribute(traceAttrDecision, decision)
+	}
+}
@@ -0,0 +1,98 @@
+package cors
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeSpan struct {
+	name  string
+	attrs map[string]string
+	ended bool
+}
+
+func (s *fakeSpan) SetAttribute(key, value string) {
+	s.attrs[key] = value
+}
+
+func (s *fakeSpan) End() {
+	s.ended = true
+}
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &fakeSpan{name: name, attrs: make(map[string]string)}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestTracer(t *testing.T) {
+	var tests = []struct {
+		name     string
+		method   string
+		origin   string
+		reqHdrs  string
+		matcher  string
+		decision string
+	}{
+		{"static", "GET", "http://foobar.com", "", "static", "allowed"},
+		{"pattern", "GET", "http://api.bar.com", "", "pattern", "allowed"},
+		{"not allowed", "GET", "http://baz.com", "", "none", "origin_not_allowed"},
+		{"method not allowed", "PUT", "http://foobar.com", "", "static", "method_not_allowed"},
+		{"preflight", "OPTIONS", "http://foobar.com", "", "static", "preflight_allowed"},
+		{"preflight headers not allowed", "OPTIONS", "http://foobar.com", "X-Header-1", "static", "request_headers_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &fakeTracer{}
+			f := Filter(Config{
+				AllowedOrigins: "http://foobar.com,*.bar.com",
+				Tracer:         tracer,
+			})
+
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+			req.Header.Add("Origin", tt.origin)
+			req.Header.Add("Access-Control-Request-Method", "GET")
+			if tt.reqHdrs != "" {
+				req.Header.Add("Access-Control-Request-Headers", tt.reqHdrs)
+			}
+
+			f(testHandler).ServeHTTP(res, req)
+
+			if len(tracer.spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(tracer.spans))
+			}
+			s := tracer.spans[0]
+			if !s.ended {
+				t.Errorf("span not ended")
+			}
+			want := map[string]string{"cors.origin": tt.origin, "cors.matcher": tt.matcher, "cors.decision": tt.decision}
+			for k, v := range want {
+				if s.attrs[k] != v {
+					t.Errorf("attribute %s got %q, want %q", k, s.attrs[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTracerSameOrigin(t *testing.T) {
+	tracer := &fakeTracer{}
+	f := Filter(Config{Tracer: tracer})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+
+	f(testHandler).ServeHTTP(res, req)
+
+	if len(tracer.spans) != 0 {
+		t.Errorf("got %d spans for a same origin request, want 0", len(tracer.spans))
+	}
+}
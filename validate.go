@@ -0,0 +1,290 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// the ConfigIssue codes
+const (
+	IssueCredentialsWithWildcard     = "CREDENTIALS_WITH_WILDCARD"
+	IssueOptionsNotAllowed           = "OPTIONS_NOT_ALLOWED"
+	IssueInvalidOriginGlob           = "INVALID_ORIGIN_GLOB"
+	IssueEmptyOrigin                 = "EMPTY_ORIGIN"
+	IssueOriginWithoutScheme         = "ORIGIN_WITHOUT_SCHEME"
+	IssueOriginTrailingSlash         = "ORIGIN_TRAILING_SLASH"
+	IssueInvalidRefreshInterval      = "INVALID_REFRESH_INTERVAL"
+	IssueInvalidScheme               = "INVALID_SCHEME"
+	IssueInvalidMethod               = "INVALID_METHOD"
+	IssueInvalidHeader               = "INVALID_HEADER"
+	IssueNegativeMaxAge              = "NEGATIVE_MAX_AGE"
+	IssueNegativeMaxRequestMethodLen = "NEGATIVE_MAX_REQUEST_METHOD_LEN"
+	IssueTooManyOrigins              = "TOO_MANY_ORIGINS"
+	IssueOriginPatternTooComplex     = "ORIGIN_PATTERN_TOO_COMPLEX"
+	IssueInvalidResourcePolicy       = "INVALID_RESOURCE_POLICY"
+	IssueInvalidPortRange            = "INVALID_PORT_RANGE"
+	IssueInvalidOriginTemplate       = "INVALID_ORIGIN_TEMPLATE"
+	IssueInvalidRejectStatus         = "INVALID_REJECT_STATUS"
+	IssueInvalidMethodAdvertisement  = "INVALID_METHOD_ADVERTISEMENT"
+	IssueCacheWithoutMetrics         = "CACHE_WITHOUT_METRICS"
+)
+
+// ConfigIssue a problem found validating a Config
+type ConfigIssue struct {
+	Field   string // the Config field, e.g. "AllowedOrigins"
+	Code    string // one of the Issue* codes
+	Message string // human readable description
+}
+
+// ConfigIssuer implemented by the errors that carry the list of the config issues, like *ConfigError
+type ConfigIssuer interface {
+	ConfigIssues() []ConfigIssue
+}
+
+// ConfigError the issues found validating a Config
+type ConfigError struct {
+	Issues []ConfigIssue
+}
+
+// Error list all the issues, one per line
+func (e *ConfigError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.Message
+	}
+
+	return "cors: invalid configuration:\n - " + strings.Join(messages, "\n - ")
+}
+
+// ConfigIssues return the issues found
+func (e *ConfigError) ConfigIssues() []ConfigIssue {
+	return e.Issues
+}
+
+// ValidateConfig check the config and return a *ConfigError listing all the problems found, or nil if the config is valid
+func ValidateConfig(config Config) error {
+	var issues []ConfigIssue
+	add := func(field, code, format string, v ...interface{}) {
+		issues = append(issues, ConfigIssue{Field: field, Code: code, Message: fmt.Sprintf(format, v...)})
+	}
+
+	if n := strings.Count(config.AllowedOrigins, ",") + 1; len(config.AllowedOrigins) > 0 && config.MaxOrigins > 0 && n > config.MaxOrigins {
+		add("AllowedOrigins", IssueTooManyOrigins, "AllowedOrigins has %d origins, more than MaxOrigins = %d", n, config.MaxOrigins)
+	}
+
+	maxPatternSize := DefaultMaxOriginPatternSize
+	if config.MaxOriginPatternSize > 0 {
+		maxPatternSize = config.MaxOriginPatternSize
+	}
+
+	allowAll := len(config.AllowedOrigins) == 0 && config.AllowOriginFunc == nil && len(config.OriginMatchers) == 0 && config.OriginClaimVerifier == nil && config.OriginsLoader == nil
+	for _, origin := range strings.Split(config.AllowedOrigins, ",") {
+		if len(config.AllowedOrigins) == 0 {
+			break
+		}
+		validateOrigin("AllowedOrigins", strings.TrimSpace(origin), maxPatternSize, add)
+		if strings.TrimSpace(origin) == OriginMatchAll {
+			allowAll = true
+		}
+	}
+
+	if (config.AllowCredentials || config.AllowCredentialsFunc != nil) && allowAll {
+		add("AllowCredentials", IssueCredentialsWithWildcard, "AllowCredentials can't be used when all origins are allowed, list the allowed origins in AllowedOrigins")
+	}
+
+	if config.OriginsRefreshInterval < 0 {
+		add("OriginsRefreshInterval", IssueInvalidRefreshInterval, "OriginsRefreshInterval = %s must not be negative", config.OriginsRefreshInterval)
+	} else if config.OriginsRefreshInterval > 0 && config.OriginsLoader == nil {
+		add("OriginsRefreshInterval", IssueInvalidRefreshInterval, "OriginsRefreshInterval = %s is useless without OriginsLoader", config.OriginsRefreshInterval)
+	}
+
+	if len(config.AllowedSchemes) > 0 {
+		for _, scheme := range strings.Split(config.AllowedSchemes, ",") {
+			if s := strings.TrimSpace(scheme); !isToken(s) {
+				add("AllowedSchemes", IssueInvalidScheme, "AllowedSchemes contains the invalid scheme %q", s)
+			}
+		}
+	}
+
+	if len(config.AllowedMethods) > 0 {
+		for _, method := range strings.Split(config.AllowedMethods, ",") {
+			if !isToken(method) {
+				add("AllowedMethods", IssueInvalidMethod, "AllowedMethods contains the invalid method %q, methods must be comma separated without spaces", method)
+			}
+		}
+	}
+
+	if h := strings.TrimSpace(config.AllowedHeaders); len(h) > 0 && h != "*" && h != NoAllowedHeaders {
+		for _, header := range strings.Split(config.AllowedHeaders, ",") {
+			if s := strings.TrimSpace(header); !isToken(s) {
+				add("AllowedHeaders", IssueInvalidHeader, "AllowedHeaders contains the invalid header name %q", s)
+			}
+		}
+	}
+
+	for method, headers := range config.AllowedHeadersByMethod {
+		if strings.TrimSpace(headers) == "*" {
+			add("AllowedHeadersByMethod", IssueInvalidHeader, "AllowedHeadersByMethod[%q] = \"*\" isn't supported, use AllowedHeaders", method)
+			continue
+		}
+		for _, header := range strings.Split(headers, ",") {
+			if s := strings.TrimSpace(header); !isToken(s) {
+				add("AllowedHeadersByMethod", IssueInvalidHeader, "AllowedHeadersByMethod[%q] contains the invalid header name %q", method, s)
+			}
+		}
+	}
+
+	if len(config.ExposedHeaders) > 0 {
+		for _, header := range strings.Split(config.ExposedHeaders, ",") {
+			if s := strings.TrimSpace(header); !isToken(s) {
+				add("ExposedHeaders", IssueInvalidHeader, "ExposedHeaders contains the invalid header name %q", s)
+			}
+		}
+	}
+
+	if preflightOptionsSet(config) && !optionsAllowed(config.AllowedMethods) {
+		add("AllowedMethods", IssueOptionsNotAllowed, "AllowedMethods doesn't contain %s, preflight requests can't be handled and the preflight options are useless", http.MethodOptions)
+	}
+
+	if len(config.OriginTemplate) > 0 && !strings.Contains(config.OriginTemplate, OriginTemplateOrigin) {
+		add("OriginTemplate", IssueInvalidOriginTemplate, "OriginTemplate = %q must contain %s, a constant Access-Control-Allow-Origin isn't safe", config.OriginTemplate, OriginTemplateOrigin)
+	}
+
+	if config.PreflightMethodRejectStatus != 0 && !isRejectStatus(config.PreflightMethodRejectStatus) {
+		add("PreflightMethodRejectStatus", IssueInvalidRejectStatus, "PreflightMethodRejectStatus = %d must be a 4xx or 5xx status code", config.PreflightMethodRejectStatus)
+	}
+
+	if config.PreflightHeaderRejectStatus != 0 && !isRejectStatus(config.PreflightHeaderRejectStatus) {
+		add("PreflightHeaderRejectStatus", IssueInvalidRejectStatus, "PreflightHeaderRejectStatus = %d must be a 4xx or 5xx status code", config.PreflightHeaderRejectStatus)
+	}
+
+	if config.MethodAdvertisement < AdvertiseFull || config.MethodAdvertisement > AdvertiseFullPlusOptions {
+		add("MethodAdvertisement", IssueInvalidMethodAdvertisement, "MethodAdvertisement = %d is unknown", config.MethodAdvertisement)
+	}
+
+	if config.Cache != nil && config.Metrics == nil {
+		add("Cache", IssueCacheWithoutMetrics, "Cache is useless without Metrics, it's used only to track the preflight requests")
+	}
+
+	if config.MaxAge < 0 {
+		add("MaxAge", IssueNegativeMaxAge, "MaxAge = %d must not be negative", config.MaxAge)
+	}
+
+	if config.MaxRequestMethodLen < 0 {
+		add("MaxRequestMethodLen", IssueNegativeMaxRequestMethodLen, "MaxRequestMethodLen = %d must not be negative", config.MaxRequestMethodLen)
+	}
+
+	if len(strings.TrimSpace(config.PrivateNetworkOrigins)) > 0 {
+		for _, origin := range strings.Split(config.PrivateNetworkOrigins, ",") {
+			validateOrigin("PrivateNetworkOrigins", strings.TrimSpace(origin), maxPatternSize, add)
+		}
+	}
+
+	if len(config.CrossOriginResourcePolicy) > 0 && !isCrossOriginResourcePolicy(config.CrossOriginResourcePolicy) {
+		add("CrossOriginResourcePolicy", IssueInvalidResourcePolicy, "CrossOriginResourcePolicy = %q must be %q, %q or %q", config.CrossOriginResourcePolicy, CORPSameOrigin, CORPSameSite, CORPCrossOrigin)
+	}
+
+	for origin, maxAge := range config.MaxAgeByOrigin {
+		validateOrigin("MaxAgeByOrigin", strings.TrimSpace(origin), maxPatternSize, add)
+		if maxAge < 0 {
+			add("MaxAgeByOrigin", IssueNegativeMaxAge, "MaxAgeByOrigin[%q] = %d must not be negative", origin, maxAge)
+		}
+	}
+
+	for origin := range config.DeprecatedOrigins {
+		validateOrigin("DeprecatedOrigins", strings.TrimSpace(origin), maxPatternSize, add)
+	}
+
+	if len(issues) > 0 {
+		return &ConfigError{Issues: issues}
+	}
+
+	return nil
+}
+
+// validateOrigin check a single allowed origin entry of field
+func validateOrigin(field, origin string, maxPatternSize int, add func(field, code, format string, v ...interface{})) {
+	if strings.Contains(origin, "!") {
+		// a pattern with its exclusions, e.g. "*.example.com !admin.example.com"
+		for _, f := range strings.Fields(origin) {
+			if f == "!" {
+				add(field, IssueEmptyOrigin, "%s contains an empty exclusion in %q", field, origin)
+			} else if !strings.HasPrefix(f, "!") {
+				validateOrigin(field, f, maxPatternSize, add)
+			}
+		}
+		return
+	}
+
+	switch {
+	case origin == "":
+		add(field, IssueEmptyOrigin, "%s contains an empty origin, check for a trailing or double comma", field)
+	case origin == OriginMatchAll || origin == OriginSelf || origin == "null":
+	case strings.HasPrefix(origin, "*."), strings.HasPrefix(origin, ".") && !strings.ContainsAny(origin, "*?"):
+	case !strings.Contains(origin, "://"):
+		add(field, IssueOriginWithoutScheme, "origin %q has no scheme, e.g. use \"https://%s\"", origin, origin)
+	case strings.Contains(origin, "**"):
+		add(field, IssueInvalidOriginGlob, "origin %q isn't a valid pattern, \"**\" is ambiguous, use a single \"*\"", origin)
+	case strings.ContainsAny(origin[:strings.Index(origin, "://")], "*?") && !strings.HasPrefix(origin, "*://"):
+		add(field, IssueInvalidOriginGlob, "origin %q isn't a valid pattern, the scheme can be only \"*\" or a static one", origin)
+	case strings.HasSuffix(origin, "/"):
+		add(field, IssueOriginTrailingSlash, "origin %q must not end with a slash", origin)
+	case strings.Contains(parseOrigin(origin).port, "-"):
+		if _, ok := parsePortRange(origin); !ok {
+			add(field, IssueInvalidPortRange, "origin %q has an invalid port range, e.g. use \"http://localhost:8000-8999\"", origin)
+		}
+	case strings.Contains(origin, "*"):
+		if _, err := originPattern(origin, maxPatternSize); err != nil {
+			add(field, IssueOriginPatternTooComplex, "%s", err)
+		}
+	}
+}
+
+// preflightOptionsSet return true if an option that applies only to preflight requests is set
+func preflightOptionsSet(config Config) bool {
+	return config.MaxAge > 0 || len(config.MaxAgeByOrigin) > 0 || len(config.AllowedHeaders) > 0 || len(config.AllowedHeadersByMethod) > 0 || config.AllowPrivateNetwork || len(config.PrivateNetworkOrigins) > 0 || config.MethodsProvider != nil
+}
+
+// optionsAllowed return true if OPTIONS is in the allowed methods, the default ones included
+func optionsAllowed(methods string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+
+	for _, m := range strings.Split(methods, ",") {
+		if strings.EqualFold(m, http.MethodOptions) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isToken return true if s is a not empty HTTP token, as required for methods and header names
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MustFilter like Filter, but validate the config first and panic with a message listing all the problems found
+func MustFilter(config Config) (fn func(next http.Handler) http.Handler) {
+	if err := ValidateConfig(config); err != nil {
+		panic(err.Error())
+	}
+
+	return Filter(config)
+}
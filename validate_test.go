@@ -0,0 +1,139 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var tests = []struct {
+		name    string
+		config  Config
+		problem string
+	}{
+		{"default", Config{}, ""},
+		{"valid", Config{AllowedOrigins: "http://foobar.com, *.bar.com, https://*.example.*, $self", AllowedMethods: "GET,POST,OPTIONS", AllowedHeaders: "X-Header-1, X-Header-2", AllowCredentials: true}, ""},
+		{"no scheme", Config{AllowedOrigins: "foobar.com"}, `origin "foobar.com" has no scheme`},
+		{"empty origin", Config{AllowedOrigins: "http://foobar.com,,http://bar.com"}, "empty origin"},
+		{"trailing slash", Config{AllowedOrigins: "http://foobar.com/"}, "must not end with a slash"},
+		{"credentials", Config{AllowCredentials: true}, "AllowCredentials"},
+		{"methods with spaces", Config{AllowedMethods: "GET, POST"}, `invalid method " POST"`},
+		{"invalid header", Config{AllowedHeaders: "X-Header-1, X Header"}, `invalid header name "X Header"`},
+		{"invalid exposed header", Config{ExposedHeaders: "X:Header"}, `invalid header name "X:Header"`},
+		{"negative max age", Config{MaxAge: -1}, "MaxAge = -1"},
+		{"refresh without loader", Config{OriginsRefreshInterval: 1}, "without OriginsLoader"},
+		{"same origin resource policy", Config{CrossOriginResourcePolicy: CORPSameOrigin}, ""},
+		{"same site resource policy", Config{CrossOriginResourcePolicy: CORPSameSite}, ""},
+		{"cross origin resource policy", Config{CrossOriginResourcePolicy: CORPCrossOrigin}, ""},
+		{"invalid resource policy", Config{CrossOriginResourcePolicy: "Same-Origin"}, `CrossOriginResourcePolicy = "Same-Origin"`},
+		{"preflight reject status", Config{PreflightMethodRejectStatus: http.StatusBadRequest, PreflightHeaderRejectStatus: http.StatusBadRequest}, ""},
+		{"invalid preflight reject status", Config{PreflightHeaderRejectStatus: http.StatusOK}, "PreflightHeaderRejectStatus = 200"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(tt.config)
+			if tt.problem == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("got %v, want an error containing %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestConfigIssues(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config Config
+		field  string
+		code   string
+	}{
+		{"credentials with wildcard", Config{AllowedOrigins: "*", AllowCredentials: true}, "AllowCredentials", IssueCredentialsWithWildcard},
+		{"options not allowed", Config{AllowedMethods: "GET,POST", MaxAge: 600}, "AllowedMethods", IssueOptionsNotAllowed},
+		{"double star glob", Config{AllowedOrigins: "http://**.foobar.com"}, "AllowedOrigins", IssueInvalidOriginGlob},
+		{"scheme glob", Config{AllowedOrigins: "http*://foobar.com"}, "AllowedOrigins", IssueInvalidOriginGlob},
+		{"empty origin", Config{AllowedOrigins: "http://foobar.com,"}, "AllowedOrigins", IssueEmptyOrigin},
+		{"origin without scheme", Config{MaxAgeByOrigin: map[string]int{"foobar.com": 10}}, "MaxAgeByOrigin", IssueOriginWithoutScheme},
+		{"private network origin without scheme", Config{PrivateNetworkOrigins: "http://intranet.foobar.com, foobar.com"}, "PrivateNetworkOrigins", IssueOriginWithoutScheme},
+		{"origin trailing slash", Config{AllowedOrigins: "http://foobar.com/"}, "AllowedOrigins", IssueOriginTrailingSlash},
+		{"refresh interval", Config{OriginsRefreshInterval: -1}, "OriginsRefreshInterval", IssueInvalidRefreshInterval},
+		{"scheme", Config{AllowedSchemes: "http,ht tp"}, "AllowedSchemes", IssueInvalidScheme},
+		{"method", Config{AllowedMethods: "GET,,OPTIONS"}, "AllowedMethods", IssueInvalidMethod},
+		{"header", Config{AllowedHeaders: "X Header"}, "AllowedHeaders", IssueInvalidHeader},
+		{"header by method", Config{AllowedHeadersByMethod: map[string]string{"PUT": "X-Header-1,X Header"}}, "AllowedHeadersByMethod", IssueInvalidHeader},
+		{"max age", Config{MaxAge: -1}, "MaxAge", IssueNegativeMaxAge},
+		{"max request method len", Config{MaxRequestMethodLen: -1}, "MaxRequestMethodLen", IssueNegativeMaxRequestMethodLen},
+		{"pattern too complex", Config{AllowedOrigins: "http://*" + strings.Repeat("a?", 100), MaxOriginPatternSize: 100}, "AllowedOrigins", IssueOriginPatternTooComplex},
+		{"too many origins", Config{AllowedOrigins: "http://a.com,http://b.com,http://c.com", MaxOrigins: 2}, "AllowedOrigins", IssueTooManyOrigins},
+		{"cross origin resource policy", Config{CrossOriginResourcePolicy: "same_site"}, "CrossOriginResourcePolicy", IssueInvalidResourcePolicy},
+		{"port range", Config{AllowedOrigins: "http://localhost:8999-8000"}, "AllowedOrigins", IssueInvalidPortRange},
+		{"origin template", Config{OriginTemplate: "*", AllowedOrigins: "http://foobar.com", AllowCredentials: true}, "OriginTemplate", IssueInvalidOriginTemplate},
+		{"preflight reject status", Config{PreflightMethodRejectStatus: 302}, "PreflightMethodRejectStatus", IssueInvalidRejectStatus},
+		{"method advertisement", Config{MethodAdvertisement: 7}, "MethodAdvertisement", IssueInvalidMethodAdvertisement},
+		{"cache without metrics", Config{Cache: newMemoryCache(time.Now)}, "Cache", IssueCacheWithoutMetrics},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(tt.config)
+			issuer, ok := err.(ConfigIssuer)
+			if !ok {
+				t.Fatalf("got %v, want a ConfigIssuer", err)
+			}
+			issues := issuer.ConfigIssues()
+			if len(issues) != 1 {
+				t.Fatalf("got %d issues %+v, want 1", len(issues), issues)
+			}
+			if issues[0].Field != tt.field || issues[0].Code != tt.code || issues[0].Message == "" {
+				t.Errorf("got %+v, want field %s and code %s", issues[0], tt.field, tt.code)
+			}
+		})
+	}
+}
+
+func TestMustFilterPanic(t *testing.T) {
+	defer func() {
+		r := recover()
+		msg, ok := r.(string)
+		if !ok {
+			t.Fatalf("got %v, want a panic with a message", r)
+		}
+		for _, problem := range []string{`origin "foobar.com" has no scheme`, `invalid method " PUT"`, "MaxAge = -5"} {
+			if !strings.Contains(msg, problem) {
+				t.Errorf("panic message %q doesn't contain %q", msg, problem)
+			}
+		}
+		if n := strings.Count(msg, "\n - "); n != 3 {
+			t.Errorf("got %d problems, want 3 in %q", n, msg)
+		}
+	}()
+
+	MustFilter(Config{
+		AllowedOrigins: "foobar.com",
+		AllowedMethods: "GET, PUT",
+		MaxAge:         -5,
+	})
+}
+
+func TestMustFilter(t *testing.T) {
+	f := MustFilter(Config{AllowedOrigins: "http://foobar.com"})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Add("Origin", "http://foobar.com")
+
+	f(testHandler).ServeHTTP(res, req)
+
+	assertHeaders(t, res.Header(), map[string]string{
+		"Access-Control-Allow-Origin": "http://foobar.com",
+	})
+	assertResponse(t, res, http.StatusOK)
+}
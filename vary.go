@@ -0,0 +1,67 @@
+package cors
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// mergeVary merge the Vary header values, deduplicated case-insensitively and sorted.
+// The first spelling of each value is kept, "*" wins over any other value
+func mergeVary(values []string) string {
+	seen := make(map[string]bool)
+	var merged []string
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if s == "*" {
+				return "*"
+			}
+			if k := strings.ToLower(s); !seen[k] {
+				seen[k] = true
+				merged = append(merged, s)
+			}
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return strings.ToLower(merged[i]) < strings.ToLower(merged[j])
+	})
+
+	return strings.Join(merged, ", ")
+}
+
+// canonicalVary replace the Vary headers with the merged one
+func canonicalVary(h http.Header) {
+	if values := h[VaryHeader]; len(values) > 0 {
+		h.Set(VaryHeader, mergeVary(values))
+	}
+}
+
+// addVary add to the Vary header the values not listed yet, compared case-insensitively,
+// so the filter can run twice on the same response without doubling them
+func addVary(h http.Header, values ...string) {
+	listed := make(map[string]bool)
+	for _, v := range h[VaryHeader] {
+		for _, s := range strings.Split(v, ",") {
+			listed[strings.ToLower(strings.TrimSpace(s))] = true
+		}
+	}
+	if listed["*"] {
+		return
+	}
+
+	var missing []string
+	for _, v := range values {
+		if !listed[strings.ToLower(v)] {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		h.Add(VaryHeader, strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,98 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergeVary(t *testing.T) {
+	var tests = []struct {
+		in  []string
+		out string
+	}{
+		{[]string{"Origin"}, "Origin"},
+		{[]string{"Origin", "Accept-Encoding"}, "Accept-Encoding, Origin"},
+		{[]string{"origin, Accept-Encoding", "Origin", " accept-encoding ,,"}, "Accept-Encoding, origin"},
+		{[]string{"Origin", "*"}, "*"},
+	}
+
+	for _, tt := range tests {
+		if s := mergeVary(tt.in); s != tt.out {
+			t.Errorf("mergeVary(%q) got %q, want %q", tt.in, s, tt.out)
+		}
+	}
+}
+
+func TestCanonicalVary(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		vary   string
+	}{
+		{"request", "GET", "Accept-Encoding, Origin"},
+		{"preflight", "OPTIONS", "Accept-Encoding, Access-Control-Request-Headers, Access-Control-Request-Method, Origin"},
+	}
+
+	handlers := map[string]http.Handler{
+		"write": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.Header().Add("Vary", "origin")
+			w.Write([]byte("bar"))
+		}),
+		"no write": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.Header().Add("Vary", "origin")
+		}),
+	}
+
+	for _, tt := range tests {
+		for name, h := range handlers {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				f := Filter(Config{
+					AllowedOrigins: "http://foobar.com",
+					ForwardRequest: true,
+					CanonicalVary:  true,
+				})
+
+				res := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.method, "http://example.com/foo", nil)
+				req.Header.Add("Origin", "http://foobar.com")
+				req.Header.Add("Access-Control-Request-Method", "GET")
+
+				f(h).ServeHTTP(res, req)
+
+				if v := res.Header()["Vary"]; len(v) != 1 || v[0] != tt.vary {
+					t.Errorf("Invalid header `Vary', wanted `%s', got `%q'", tt.vary, v)
+				}
+				assertResponse(t, res, http.StatusOK)
+			})
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	var tests = []struct {
+		vary   []string
+		values []string
+		out    []string
+	}{
+		{nil, []string{"Origin"}, []string{"Origin"}},
+		{[]string{"Accept-Encoding"}, []string{"Origin"}, []string{"Accept-Encoding", "Origin"}},
+		{[]string{"Accept-Encoding, origin"}, []string{"Origin"}, []string{"Accept-Encoding, origin"}},
+		{[]string{"Origin"}, []string{"Origin", "Access-Control-Request-Method"}, []string{"Origin", "Access-Control-Request-Method"}},
+		{[]string{"*"}, []string{"Origin"}, []string{"*"}},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		for _, v := range tt.vary {
+			h.Add("Vary", v)
+		}
+		addVary(h, tt.values...)
+		if v := h["Vary"]; strings.Join(v, "|") != strings.Join(tt.out, "|") {
+			t.Errorf("addVary(%q, %q) got %q, want %q", tt.vary, tt.values, v, tt.out)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package cors
+
+import "runtime/debug"
+
+// develVersion the version reported when the build info doesn't know the release, e.g. in the tests or in a local checkout
+const develVersion = "(devel)"
+
+// modulePath the path of the module, to find it in the build info
+const modulePath = "github.com/vpxyz/cors"
+
+// Version return the version of the package, as reported by the build info of the running binary, e.g. "v1.1.0".
+// If it's unknown, it returns "(devel)"
+func Version() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath && dep.Version != "" {
+				return dep.Version
+			}
+		}
+		if info.Main.Path == modulePath && info.Main.Version != "" {
+			return info.Main.Version
+		}
+	}
+
+	return develVersion
+}
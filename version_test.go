@@ -0,0 +1,23 @@
+package cors
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	v := Version()
+	if v != "(devel)" && !regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`).MatchString(v) {
+		t.Errorf("got %q, want a semantic version or (devel)", v)
+	}
+
+	buf := new(bytes.Buffer)
+	Filter(Config{Logger: log.New(buf, "", 0)})
+
+	if !strings.Contains(buf.String(), " version="+v+" ") {
+		t.Errorf("the startup line %q doesn't contain the version", buf.String())
+	}
+}
@@ -0,0 +1,148 @@
+package cors
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// errHijackNotSupported returned by Hijack if the underlying ResponseWriter isn't an http.Hijacker
+var errHijackNotSupported = errors.New("cors: the ResponseWriter doesn't implement http.Hijacker")
+
+// hijack hijack the connection of w, if it's an http.Hijacker
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, errHijackNotSupported
+}
+
+// headerWriter rewrite the response headers just before they are written,
+// so the rewrite sees the headers added by the filter and by the next handlers too
+type headerWriter struct {
+	http.ResponseWriter
+	rewrite func(h http.Header)
+	done    bool
+}
+
+// apply rewrite the headers, only once
+func (w *headerWriter) apply() {
+	if w.done {
+		return
+	}
+	w.done = true
+
+	w.rewrite(w.ResponseWriter.Header())
+}
+
+// WriteHeader rewrite the headers and write the status code
+func (w *headerWriter) WriteHeader(code int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write rewrite the headers and write the body
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the underlying ResponseWriter does
+func (w *headerWriter) Flush() {
+	w.apply()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying ResponseWriter does, e.g. for the WebSocket handlers
+func (w *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+// Unwrap return the underlying ResponseWriter, for http.ResponseController
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusWriter write always the status code code, whatever the next handlers ask
+type statusWriter struct {
+	http.ResponseWriter
+	code    int
+	written bool
+}
+
+// writeStatus write the status code, only once
+func (w *statusWriter) writeStatus() {
+	if w.written {
+		return
+	}
+	w.written = true
+
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// WriteHeader write the forced status code, code is ignored
+func (w *statusWriter) WriteHeader(code int) {
+	w.writeStatus()
+}
+
+// Write write the forced status code and the body
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.writeStatus()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the underlying ResponseWriter does
+func (w *statusWriter) Flush() {
+	w.writeStatus()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// corsResponseHeaders the single valued CORS headers owned by the filter, always written with Set, and removed by serverErrorWriter
+var corsResponseHeaders = []string{
+	AccessControlAllowOrigin,
+	AccessControlAllowCredentials,
+	AccessControlExposeHeaders,
+	AccessControlAllowMethods,
+	AccessControlAllowHeaders,
+	AccessControlControlMaxAge,
+	AccessControlAllowPrivateNetwork,
+}
+
+// serverErrorWriter remove the CORS headers from the responses with a 5xx status code
+type serverErrorWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader remove the CORS headers if code is a server error, and write the status code
+func (w *serverErrorWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError {
+		h := w.ResponseWriter.Header()
+		for _, name := range corsResponseHeaders {
+			h.Del(name)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher, if the underlying ResponseWriter does
+func (w *serverErrorWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying ResponseWriter does
+func (w *serverErrorWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+// Unwrap return the underlying ResponseWriter, for http.ResponseController
+func (w *serverErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}